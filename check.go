@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	hibpapi "github.com/gopasspw/gopass-hibp/pkg/hibp/api"
+	hibpdump "github.com/gopasspw/gopass-hibp/pkg/hibp/dump"
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/termio"
+)
+
+// checkValue is a single password or hash to check.
+type checkValue struct {
+	// label identifies the value in the output, it never contains the value.
+	label string
+	sum   string
+}
+
+// checkResult is the result of looking up a single hash.
+type checkResult struct {
+	found bool
+	// count is zero if it's unknown.
+	count uint64
+	// probable is set for unconfirmed filter hits.
+	probable bool
+	err      error
+}
+
+// checkSources selects the backend of the check command. The API is used
+// unless dumps or a filter are given.
+type checkSources struct {
+	dumps   []string
+	filter  string
+	confirm bool
+}
+
+// readCheckInput reads one password, or SHA-1 hash if hashes is set, per line.
+// If in is a terminal the values are read without echo.
+func readCheckInput(ctx context.Context, in io.Reader, hashes bool) ([]checkValue, error) {
+	var lines []string
+	if fh, ok := in.(*os.File); ok && isTerminal(fh) {
+		ctx = ctxutil.WithTerminal(ctx, true)
+		for {
+			line, err := termio.AskForPassword(ctx, "password or hash (empty line to finish)", false)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("failed to read input: %w", err)
+			}
+			if line == "" {
+				break
+			}
+			lines = append(lines, line)
+		}
+	} else {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read input: %w", err)
+		}
+	}
+
+	values := make([]checkValue, 0, len(lines))
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		label := fmt.Sprintf("line %d", i+1)
+		if !hashes {
+			values = append(values, checkValue{label: label, sum: sha1hex(line)})
+
+			continue
+		}
+
+		line = strings.ToUpper(strings.TrimSpace(line))
+		if _, err := hex.DecodeString(line); err != nil || len(line) != 40 {
+			return nil, fmt.Errorf("%w: %s is not a SHA-1 hash", errSetup, label)
+		}
+		values = append(values, checkValue{label: label, sum: line})
+	}
+
+	return values, nil
+}
+
+func isTerminal(fh *os.File) bool {
+	fi, err := fh.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// secretValues returns the password and the selected fields of a secret.
+func (s *hibp) secretValues(ctx context.Context, name string) ([]checkValue, error) {
+	sec, err := s.gp.Get(ctx, name, "latest")
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve secret '%s': %w", name, err)
+	}
+
+	fields, err := s.fields.values(name, sec)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(fields))
+	for field := range fields {
+		keys = append(keys, field)
+	}
+	sort.Strings(keys)
+
+	var values []checkValue
+	for _, field := range keys {
+		for _, value := range fields[field] {
+			loc := location{Secret: name, Field: field}
+			values = append(values, checkValue{label: loc.String(), sum: sha1hex(value)})
+		}
+	}
+	if len(values) < 1 {
+		return nil, fmt.Errorf("secret '%s' has no password", name)
+	}
+
+	return values, nil
+}
+
+// CheckValues looks up the given values and prints one line per value.
+func (s *hibp) CheckValues(ctx context.Context, values []checkValue, src checkSources) error {
+	if len(values) < 1 {
+		return fmt.Errorf("%w: nothing to check", errSetup)
+	}
+
+	sums := make([]string, 0, len(values))
+	for _, v := range values {
+		sums = append(sums, v.sum)
+	}
+
+	var results map[string]checkResult
+	var err error
+	switch {
+	case src.filter != "":
+		results, err = lookupFilter(ctx, src.filter, src.confirm, sums)
+	case len(src.dumps) > 0:
+		results, err = s.lookupDumps(ctx, src.dumps, sums)
+	default:
+		results = lookupAPI(sums)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := s.out
+	if w == nil {
+		w = os.Stdout
+	}
+
+	var leaked, failed bool
+	for _, v := range values {
+		res := results[v.sum]
+		prefix := v.sum[:5]
+		switch {
+		case res.err != nil:
+			failed = true
+			fmt.Fprintf(w, "%s (%s): %s\n", v.label, prefix, color.YellowString("lookup failed: %s", res.err))
+		case !res.found:
+			fmt.Fprintf(w, "%s (%s): not found\n", v.label, prefix)
+		case res.probable:
+			leaked = true
+			fmt.Fprintf(w, "%s (%s): %s\n", v.label, prefix, color.RedString("probably leaked (use --confirm to verify)"))
+		case res.count < 1:
+			leaked = true
+			fmt.Fprintf(w, "%s (%s): %s\n", v.label, prefix, color.RedString("leaked"))
+		default:
+			leaked = true
+			fmt.Fprintf(w, "%s (%s): %s\n", v.label, prefix, color.RedString("leaked, seen %d times", res.count))
+		}
+	}
+
+	switch {
+	case leaked:
+		return errLeaks
+	case failed:
+		return fmt.Errorf("%w: some lookups failed", errIncomplete)
+	default:
+		return nil
+	}
+}
+
+func lookupAPI(sums []string) map[string]checkResult {
+	results := make(map[string]checkResult, len(sums))
+	for _, sum := range sums {
+		if _, found := results[sum]; found {
+			continue
+		}
+
+		freq, err := hibpapi.Lookup(sum)
+		results[sum] = checkResult{found: freq > 0, count: freq, err: err}
+	}
+
+	return results
+}
+
+func (s *hibp) lookupDumps(ctx context.Context, dumps []string, sums []string) (map[string]checkResult, error) {
+	scanner, err := hibpdump.New(dumps...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create new HIBP Dump scanner: %w", errSetup, err)
+	}
+
+	sorted := append([]string{}, sums...)
+	sort.Strings(sorted)
+	counts := scanner.LookupCounts(ctx, sorted)
+
+	var scanErr error
+	if err := s.printScanStats(scanner.Stats()); err != nil {
+		scanErr = err
+	}
+
+	results := make(map[string]checkResult, len(sums))
+	for _, sum := range sums {
+		if count, found := counts[sum]; found {
+			results[sum] = checkResult{found: true, count: count}
+
+			continue
+		}
+		// a hash not found in an unreadable dump is not known to be safe
+		results[sum] = checkResult{err: scanErr}
+	}
+
+	return results, nil
+}
+
+func lookupFilter(ctx context.Context, filter string, confirm bool, sums []string) (map[string]checkResult, error) {
+	f, err := hibpdump.OpenFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open HIBP filter: %w", errSetup, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	sorted := append([]string{}, sums...)
+	sort.Strings(sorted)
+
+	hits, errs := f.LookupBatchErr(ctx, sorted)
+	results := make(map[string]checkResult, len(sums))
+	for sum, err := range errs {
+		results[sum] = checkResult{err: err}
+	}
+	for _, hit := range hits {
+		if !confirm {
+			results[hit] = checkResult{found: true, probable: true}
+
+			continue
+		}
+
+		freq, err := hibpapi.Lookup(hit)
+		results[hit] = checkResult{found: freq > 0, count: freq, err: err}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	hibpdump "github.com/gopasspw/gopass-hibp/pkg/hibp/dump"
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/gopass/apimock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCheckInput(t *testing.T) {
+	ctx := t.Context()
+
+	values, err := readCheckInput(ctx, strings.NewReader("foobar\n\nsecret\r\n"), false)
+	require.NoError(t, err)
+	assert.Equal(t, []checkValue{
+		{label: "line 1", sum: sha1hex("foobar")},
+		{label: "line 3", sum: sha1hex("secret")},
+	}, values)
+
+	values, err = readCheckInput(ctx, strings.NewReader("8843d7f92416211de9ebb963ff4ce28125932878\n"), true)
+	require.NoError(t, err)
+	assert.Equal(t, []checkValue{{label: "line 1", sum: sha1hex("foobar")}}, values)
+
+	_, err = readCheckInput(ctx, strings.NewReader("foobar\n"), true)
+	require.Error(t, err)
+	// the value is never part of the error
+	assert.NotContains(t, err.Error(), "foobar")
+}
+
+func TestCheckValues(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx := t.Context()
+	ctx = ctxutil.WithHidden(ctx, true)
+
+	fn := filepath.Join(dir, "leaked.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSample+"\n8843D7F92416211DE9EBB963FF4CE28125932878:5\n"), 0o644))
+
+	buf := &bytes.Buffer{}
+	act := &hibp{
+		gp:  apimock.New(),
+		out: buf,
+	}
+	require.NoError(t, act.gp.Set(ctx, "web/a", &apimock.Secret{Buf: []byte("foobar\npin: secret\n")}))
+
+	values, err := readCheckInput(ctx, strings.NewReader("foobar\nsecret\n"), false)
+	require.NoError(t, err)
+	require.ErrorIs(t, act.CheckValues(ctx, values, checkSources{dumps: []string{fn}}), errLeaks)
+	assert.Equal(t, "line 1 (8843D): leaked, seen 5 times\n"+
+		"line 2 (E5E9F): not found\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, act.CheckValues(ctx, values[1:], checkSources{dumps: []string{fn}}))
+
+	// a single secret
+	act.fields, err = newExtractor([]string{"pin"})
+	require.NoError(t, err)
+	values, err = act.secretValues(ctx, "web/a")
+	require.NoError(t, err)
+	assert.Equal(t, []checkValue{
+		{label: "web/a", sum: sha1hex("foobar")},
+		{label: "web/a (field pin)", sum: sha1hex("secret")},
+	}, values)
+	_, err = act.secretValues(ctx, "web/missing")
+	require.Error(t, err)
+
+	// a filter
+	scanner, err := hibpdump.New(fn)
+	require.NoError(t, err)
+	ffn := filepath.Join(dir, "filter")
+	require.NoError(t, scanner.BuildFilter(ctx, ffn, hibpdump.DefaultFalsePositiveRate, 0))
+	buf.Reset()
+	require.ErrorIs(t, act.CheckValues(ctx, values[:1], checkSources{filter: ffn}), errLeaks)
+	assert.Contains(t, buf.String(), "web/a (8843D): ")
+	assert.Contains(t, buf.String(), "probably leaked")
+
+	// a filter that can't be read is no negative result
+	require.NoError(t, os.Truncate(ffn, 48))
+	buf.Reset()
+	require.ErrorIs(t, act.CheckValues(ctx, values[:1], checkSources{filter: ffn}), errIncomplete)
+	assert.Contains(t, buf.String(), "web/a (8843D): ")
+	assert.Contains(t, buf.String(), "lookup failed")
+
+	require.Error(t, act.CheckValues(ctx, nil, checkSources{}))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/gopasspw/gopass/pkg/gopass"
+)
+
+// fieldPassword is the field name of the password of a secret.
+const fieldPassword = "password"
+
+// location is a value of a secret that is checked for leaks.
+type location struct {
+	Secret string
+	Field  string
+	// Revision is empty for the latest revision.
+	Revision string
+	// MaxCount is the hibp-max-count of the secret, if any.
+	MaxCount uint64
+}
+
+// String returns the secret name, followed by the field unless it is the
+// password and the revision unless it is the latest one.
+func (l location) String() string {
+	var details []string
+	if l.Field != fieldPassword {
+		details = append(details, "field "+l.Field)
+	}
+	if l.Revision != "" {
+		details = append(details, "revision "+l.Revision)
+	}
+	if len(details) < 1 {
+		return l.Secret
+	}
+
+	return fmt.Sprintf("%s (%s)", l.Secret, strings.Join(details, ", "))
+}
+
+// extractor extracts the values to check from a secret. The password is always
+// checked. Rules are either key names, globs matching key names (e.g.
+// "*_password") or Go templates (e.g. "{{ .Body }}"). Every non-empty line of
+// the output of a template is checked.
+type extractor struct {
+	globs     []string
+	templates []*template.Template
+}
+
+// templateData is passed to the extraction templates.
+type templateData struct {
+	Name     string
+	Password string
+	Body     string
+	Keys     []string
+	Values   map[string][]string
+}
+
+func newExtractor(rules []string) (*extractor, error) {
+	e := &extractor{}
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		if strings.Contains(rule, "{{") {
+			tmpl, err := template.New(rule).Option("missingkey=zero").Parse(rule)
+			if err != nil {
+				return nil, fmt.Errorf("invalid field template %q: %w", rule, err)
+			}
+			e.templates = append(e.templates, tmpl)
+
+			continue
+		}
+
+		rule = strings.ToLower(rule)
+		if _, err := path.Match(rule, ""); err != nil {
+			return nil, fmt.Errorf("invalid field pattern %q: %w", rule, err)
+		}
+		e.globs = append(e.globs, rule)
+	}
+
+	return e, nil
+}
+
+// values returns all values of the secret to check, keyed by field name.
+func (e *extractor) values(name string, sec gopass.Secret) (map[string][]string, error) {
+	out := make(map[string][]string, 1)
+	if pw := sec.Password(); pw != "" {
+		out[fieldPassword] = []string{pw}
+	}
+	if e == nil {
+		return out, nil
+	}
+
+	keys := sec.Keys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !e.matchKey(key) {
+			continue
+		}
+		vals, _ := sec.Values(key)
+		for _, v := range vals {
+			if v == "" {
+				continue
+			}
+			out[key] = append(out[key], v)
+		}
+	}
+
+	if len(e.templates) < 1 {
+		return out, nil
+	}
+
+	data := templateData{
+		Name:     name,
+		Password: sec.Password(),
+		Body:     sec.Body(),
+		Keys:     keys,
+		Values:   make(map[string][]string, len(keys)),
+	}
+	for _, key := range keys {
+		data.Values[key], _ = sec.Values(key)
+	}
+	for _, tmpl := range e.templates {
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, data); err != nil {
+			return out, fmt.Errorf("failed to execute field template %q: %w", tmpl.Name(), err)
+		}
+		for _, line := range strings.Split(buf.String(), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			out[tmpl.Name()] = append(out[tmpl.Name()], line)
+		}
+	}
+
+	return out, nil
+}
+
+func (e *extractor) matchKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, glob := range e.globs {
+		if ok, _ := path.Match(glob, key); ok {
+			return true
+		}
+	}
+
+	return false
+}
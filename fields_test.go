@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/gopass/apimock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractor(t *testing.T) {
+	ctx := t.Context()
+	ctx = ctxutil.WithHidden(ctx, true)
+
+	gp := apimock.New()
+	require.NoError(t, gp.Set(ctx, "db", &apimock.Secret{Buf: []byte("hunter2\n" +
+		"PIN: 1234\n" +
+		"db_password: letmein\n" +
+		"user: admin\n" +
+		"\n" +
+		"recovery-1\n" +
+		"recovery-2\n")}))
+	sec, err := gp.Get(ctx, "db", "latest")
+	require.NoError(t, err)
+
+	// only the password by default
+	var e *extractor
+	values, err := e.values("db", sec)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{fieldPassword: {"hunter2"}}, values)
+
+	e, err = newExtractor([]string{"pin", "*_password", "{{ .Body }}"})
+	require.NoError(t, err)
+	values, err = e.values("db", sec)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hunter2"}, values[fieldPassword])
+	assert.Equal(t, []string{"1234"}, values["PIN"])
+	assert.Equal(t, []string{"letmein"}, values["db_password"])
+	assert.NotContains(t, values, "user")
+	assert.Contains(t, values["{{ .Body }}"], "recovery-1")
+	assert.Contains(t, values["{{ .Body }}"], "recovery-2")
+
+	_, err = newExtractor([]string{"{{ .Body "})
+	require.Error(t, err)
+	_, err = newExtractor([]string{"[pin"})
+	require.Error(t, err)
+
+	assert.Equal(t, "db", location{Secret: "db", Field: fieldPassword}.String())
+	assert.Equal(t, "db (field pin)", location{Secret: "db", Field: "pin"}.String())
+}
@@ -1,111 +1,409 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"crypto/sha1"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"slices"
 	"sort"
+	"strings"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
 	hibpapi "github.com/gopasspw/gopass-hibp/pkg/hibp/api"
 	hibpdump "github.com/gopasspw/gopass-hibp/pkg/hibp/dump"
 	"github.com/gopasspw/gopass/pkg/ctxutil"
 	"github.com/gopasspw/gopass/pkg/debug"
 	"github.com/gopasspw/gopass/pkg/gopass"
+	"github.com/gopasspw/gopass/pkg/gopass/api"
 	"github.com/gopasspw/gopass/pkg/termio"
 )
 
+var (
+	// errLeaks is returned if leaked passwords were found.
+	errLeaks = errors.New("weak passwords found")
+	// errIncomplete is returned if some secrets or dumps could not be
+	// checked.
+	errIncomplete = errors.New("check incomplete")
+	// errSetup is returned for usage and setup errors, e.g. invalid options or
+	// dumps that can't be opened.
+	errSetup = errors.New("invalid setup")
+)
+
 type hibp struct {
 	gp gopass.Store
+	// fields selects the values to check in addition to the password
+	fields *extractor
+	// history enables checking all revisions of each secret
+	history bool
+	// selector restricts the secrets to check
+	selector *selector
+	// ignoreFile is the local ignore list, in addition to the one in the store
+	ignoreFile string
+	ignored    *ignoreList
+	// listed is the number of secrets in the store, selected the number
+	// of secrets selected for checking
+	listed   int
+	selected int
+	// internal are the secrets of gopass-hibp itself found in the store
+	internal []string
+	// optedOut are the secrets skipped by their own policy
+	optedOut []string
+	// checked are the secrets decrypted successfully
+	checked []string
+	// errors are the secrets and hashes that could not be checked
+	errors []reportError
+	// confirm is set if filter hits were looked up in the API
+	confirm bool
+	// format of the report written to out (stdout by default)
+	format   string
+	fullHash bool
+	out      io.Writer
+	// log receives progress and warnings (stdout by default)
+	log io.Writer
+	// incremental skips the lookups of secrets that didn't change since they
+	// were checked without any match. Without a date of the API data the API
+	// is queried again once recheck has passed.
+	incremental  bool
+	recheck      time.Duration
+	state        *checkState
+	source       *sourceState
+	fingerprints map[string]string
+	unchanged    map[string]bool
 }
 
-// CheckAPI checks your secrets against the HIBPv2 API.
-func (s *hibp) CheckAPI(ctx context.Context, force bool) error {
-	if !force && !termio.AskForConfirmation(ctx, "This command is checking all your secrets against the haveibeenpwned.com API.\n\nThis will send five bytes of each passwords SHA1 hash to an untrusted server!\n\nYou will be asked to unlock all your secrets!\nDo you want to continue?") {
+// CheckAPI checks your secrets against the HIBPv2 API. Matches seen less than
+// minCount times are only reported as a warning.
+func (s *hibp) CheckAPI(ctx context.Context, force bool, minCount uint64) error {
+	pwList, err := s.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !force && !termio.AskForConfirmation(ctx, fmt.Sprintf("This command is checking %s against the haveibeenpwned.com API.\n\nThis will send five bytes of each passwords SHA1 hash to an untrusted server!\n\nYou will be asked to unlock %s!\nDo you want to continue?", secretsText(pwList), s.unlockText(ctx, pwList))) {
 		return fmt.Errorf("user aborted")
 	}
 
-	shaSums, sortedShaSums, err := s.precomputeHashes(ctx)
+	shaSums, sortedShaSums, err := s.precomputeHashes(ctx, pwList)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Checking pre-computed SHA1 hashes against the HIBP API ...")
+	if s.ignored, err = loadIgnoreList(ctx, s.logWriter(), s.gp, s.ignoreFile); err != nil {
+		return fmt.Errorf("%w: %w", errSetup, err)
+	}
+
+	sortedShaSums, err = s.incrementalCheck(ctx, "api", nil, time.Now().Add(-s.recheck), shaSums, sortedShaSums)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(s.logWriter(), "Checking pre-computed SHA1 hashes against the HIBP API ...")
 
 	// compare the prepared list against all provided files
 	matchList := make([]string, 0, len(sortedShaSums))
+	rareList := make([]string, 0, len(sortedShaSums))
+	counts := make(map[string]uint64, len(sortedShaSums))
 	for _, shaSum := range sortedShaSums {
 		freq, err := hibpapi.Lookup(shaSum)
 		if err != nil {
-			fmt.Printf("Failed to check HIBP API: %s\n", err)
+			fmt.Fprintf(s.logWriter(), "Failed to check HIBP API: %s\n", err)
+			s.lookupFailed(shaSums[shaSum], shaSum, err)
 
 			continue
 		}
 		if freq < 1 {
 			continue
 		}
-		if pw, found := shaSums[shaSum]; found {
-			matchList = append(matchList, pw)
+		if _, found := shaSums[shaSum]; !found {
+			continue
+		}
+		counts[shaSum] = freq
+		if freq < minCount {
+			rareList = append(rareList, shaSum)
+
+			continue
 		}
+		matchList = append(matchList, shaSum)
 	}
 
-	return s.printMatches(matchList)
+	return s.printMatches(ctx, "api", nil, shaSums, counts, matchList, nil, rareList, minCount)
 }
 
-// CheckDump checks your secrets against the provided HIBPv2 Dumps.
-func (s *hibp) CheckDump(ctx context.Context, force bool, dumps []string) error {
-	fmt.Println("Using the HIBPv2 dumps is very expensive. If you can condone leaking a few bits of entropy per secret you should probably use the '--api' flag.")
+// CheckDump checks your secrets against the provided HIBPv2 Dumps. Matches
+// seen less than minCount times are only reported as a warning. Matches in
+// dumps without counts always fail. Dumps older than maxAge are reported as
+// a warning.
+func (s *hibp) CheckDump(ctx context.Context, force bool, dumps []string, minCount uint64, maxAge time.Duration) error {
+	fmt.Fprintln(s.logWriter(), "Using the HIBPv2 dumps is very expensive. If you can condone leaking a few bits of entropy per secret you should probably use the '--api' flag.")
 
 	if len(dumps) < 1 {
-		return fmt.Errorf("need a least one dump file")
+		return fmt.Errorf("%w: need at least one dump file", errSetup)
 	}
 
 	// New also checks if there is at least one valid dump file given
 	scanner, err := hibpdump.New(dumps...)
 	if err != nil {
-		return fmt.Errorf("failed to create new HIBP Dump scanner: %w", err)
+		return fmt.Errorf("%w: failed to create new HIBP Dump scanner: %w", errSetup, err)
 	}
+	s.printDumpAges(dumps, maxAge)
 
-	if !force && !termio.AskForConfirmation(ctx, fmt.Sprintf("This command is checking all your secrets against the haveibeenpwned.com hashes in %+v.\nYou will be asked to unlock all your secrets!\nDo you want to continue?", dumps)) {
+	pwList, err := s.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !force && !termio.AskForConfirmation(ctx, fmt.Sprintf("This command is checking %s against the haveibeenpwned.com hashes in %+v.\nYou will be asked to unlock %s!\nDo you want to continue?", secretsText(pwList), dumps, s.unlockText(ctx, pwList))) {
 		return fmt.Errorf("user aborted")
 	}
 
-	shaSums, sortedShaSums, err := s.precomputeHashes(ctx)
+	shaSums, sortedShaSums, err := s.precomputeHashes(ctx, pwList)
+	if err != nil {
+		return err
+	}
+
+	if s.ignored, err = loadIgnoreList(ctx, s.logWriter(), s.gp, s.ignoreFile); err != nil {
+		return fmt.Errorf("%w: %w", errSetup, err)
+	}
+
+	sortedShaSums, err = s.incrementalCheck(ctx, "dump", dumps, dumpsCreated(dumps), shaSums, sortedShaSums)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Checking hashes against the provided dumps. This will take a while.")
+	fmt.Fprintln(s.logWriter(), "Checking hashes against the provided dumps. This will take a while.")
 
-	matchedSums := scanner.LookupBatch(ctx, sortedShaSums)
+	matchedSums := scanner.LookupCounts(ctx, sortedShaSums)
 	debug.Log("In: %+v - Out: %+v", sortedShaSums, matchedSums)
 	matchList := make([]string, 0, len(matchedSums))
-	for _, matchedSum := range matchedSums {
-		if pw, found := shaSums[matchedSum]; found {
-			matchList = append(matchList, pw)
+	rareList := make([]string, 0, len(matchedSums))
+	for matchedSum, count := range matchedSums {
+		if _, found := shaSums[matchedSum]; !found {
+			continue
+		}
+		if count > 0 && count < minCount {
+			rareList = append(rareList, matchedSum)
+
+			continue
+		}
+		matchList = append(matchList, matchedSum)
+	}
+
+	if err := s.printScanStats(scanner.Stats()); err != nil {
+		s.errors = append(s.errors, reportError{Error: err.Error()})
+	}
+
+	return s.printMatches(ctx, "dump", dumps, shaSums, matchedSums, matchList, nil, rareList, minCount)
+}
+
+// CheckFilter checks your secrets against a probabilistic filter built from
+// the HIBPv2 dumps. Filter hits are only probable matches. If confirm is set
+// they are checked against the HIBPv2 API, sending only the prefixes of the
+// hits to the server. Confirmed matches seen less than minCount times are only
+// reported as a warning.
+func (s *hibp) CheckFilter(ctx context.Context, force bool, filter string, confirm bool, minCount uint64) error {
+	f, err := hibpdump.OpenFilter(filter)
+	if err != nil {
+		return fmt.Errorf("%w: failed to open HIBP filter: %w", errSetup, err)
+	}
+	defer f.Close() //nolint:errcheck
+	s.confirm = confirm
+
+	pwList, err := s.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !force && !termio.AskForConfirmation(ctx, fmt.Sprintf("This command is checking %s against the haveibeenpwned.com filter %s.\nYou will be asked to unlock %s!\nDo you want to continue?", secretsText(pwList), filter, s.unlockText(ctx, pwList))) {
+		return fmt.Errorf("user aborted")
+	}
+
+	shaSums, sortedShaSums, err := s.precomputeHashes(ctx, pwList)
+	if err != nil {
+		return err
+	}
+
+	if s.ignored, err = loadIgnoreList(ctx, s.logWriter(), s.gp, s.ignoreFile); err != nil {
+		return fmt.Errorf("%w: %w", errSetup, err)
+	}
+
+	sortedShaSums, err = s.incrementalCheck(ctx, "filter", []string{filter}, fileModified(filter), shaSums, sortedShaSums)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(s.logWriter(), "Checking hashes against the provided filter ...")
+
+	hits, errs := f.LookupBatchErr(ctx, sortedShaSums)
+	debug.Log("In: %+v - Out: %+v", sortedShaSums, hits)
+	for sum, err := range errs {
+		fmt.Fprintf(s.logWriter(), "Failed to check the filter: %s\n", err)
+		s.lookupFailed(shaSums[sum], sum, err)
+	}
+	matchList := make([]string, 0, len(hits))
+	probableList := make([]string, 0, len(hits))
+	rareList := make([]string, 0, len(hits))
+	counts := make(map[string]uint64, len(hits))
+	for _, hit := range hits {
+		if _, found := shaSums[hit]; !found {
+			continue
+		}
+		if !confirm {
+			probableList = append(probableList, hit)
+
+			continue
+		}
+
+		freq, err := hibpapi.Lookup(hit)
+		if err != nil {
+			fmt.Fprintf(s.logWriter(), "Failed to check HIBP API: %s\n", err)
+			s.lookupFailed(shaSums[hit], hit, err)
+			probableList = append(probableList, hit)
+
+			continue
+		}
+		// a false positive of the filter
+		if freq < 1 {
+			debug.Log("filter hit %s not confirmed by the API", hit)
+
+			continue
+		}
+		counts[hit] = freq
+		if freq < minCount {
+			rareList = append(rareList, hit)
+
+			continue
+		}
+		matchList = append(matchList, hit)
+	}
+
+	return s.printMatches(ctx, "filter", []string{filter}, shaSums, counts, matchList, probableList, rareList, minCount)
+}
+
+// list returns the names of all secrets selected for checking. Nothing is
+// decrypted yet.
+func (s *hibp) list(ctx context.Context) ([]string, error) {
+	names, err := s.gp.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	s.internal = nil
+	names = slices.DeleteFunc(names, func(name string) bool {
+		if strings.HasPrefix(name, internalPrefix) {
+			s.internal = append(s.internal, name)
+
+			return true
+		}
+
+		return false
+	})
+	pwList := s.selector.filter(names)
+	if len(pwList) < 1 && len(names) > 0 {
+		return nil, fmt.Errorf("%w: none of the %d secrets match the given paths and patterns", errSetup, len(names))
+	}
+	if len(pwList) < len(names) {
+		fmt.Fprintf(s.logWriter(), "Selected %d of %d secrets\n", len(pwList), len(names))
+	}
+	s.listed = len(names)
+	s.selected = len(pwList)
+
+	// fail before anything is decrypted instead of silently skipping the history
+	if s.history && len(pwList) > 0 {
+		if _, err := s.gp.Revisions(ctx, pwList[0]); errors.Is(err, api.ErrNotImplemented) {
+			return nil, fmt.Errorf("%w: --history needs a store that can list revisions, this version of gopass can't", errSetup)
+		}
+	}
+
+	return pwList, nil
+}
+
+// secretsText returns the number of secrets for the confirmation prompts.
+func secretsText(pwList []string) string {
+	return countText(len(pwList), "secret")
+}
+
+// countText returns n and the noun, in plural unless n is 1.
+func countText(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// unlockText returns the number of secrets a check decrypts for the
+// confirmation prompts. Besides the given secrets this includes all of their
+// revisions when checking the history and the secrets of gopass-hibp itself
+// that are read, i.e. the ignore list, the key and the incremental state.
+func (s *hibp) unlockText(ctx context.Context, pwList []string) string {
+	revisions := 0
+	if s.history {
+		for _, secret := range pwList {
+			// failures are reported when the revisions are read
+			if revs, err := s.gp.Revisions(ctx, secret); err == nil {
+				revisions += len(revs)
+			}
 		}
 	}
 
-	return s.printMatches(matchList)
+	internal := 0
+	_, err := os.Stat(s.ignoreFile)
+	hasIgnore := err == nil
+	for _, secret := range s.internal {
+		switch secret {
+		case ignoreSecret:
+			hasIgnore = true
+			internal++
+		case stateSecret:
+			if s.incremental {
+				internal++
+			}
+		}
+	}
+	// the key is needed for HMACs in the ignore lists and the incremental state
+	if slices.Contains(s.internal, keySecret) && (hasIgnore || s.incremental) {
+		internal++
+	}
+
+	parts := []string{secretsText(pwList)}
+	if revisions > 0 {
+		parts = append(parts, countText(revisions, "revision")+" of them")
+	}
+	if internal > 0 {
+		parts = append(parts, countText(internal, "secret")+" of "+name)
+	}
+	if len(parts) < 2 {
+		return parts[0]
+	}
+
+	return strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
 }
 
-func (s *hibp) precomputeHashes(ctx context.Context) (map[string]string, []string, error) {
+// precomputeHashes returns a map of the SHA1 hashes of all passwords (and other
+// selected fields) of the given secrets to the secrets using them and a sorted
+// list of the distinct hashes.
+func (s *hibp) precomputeHashes(ctx context.Context, pwList []string) (map[string][]location, []string, error) {
 	// build a map of all secrets sha sums to their names and also build a sorted (!)
 	// list of this shasums. As the hibp dump is already sorted this allows for
 	// a very efficient stream compare in O(n)
-	pwList, err := s.gp.List(ctx)
-	if err != nil {
-		return nil, nil, err
-	}
-	// map sha1sum back to secret name for reporting
-	shaSums := make(map[string]string, len(pwList))
-	// build list of sha1sums (must be sorted later!) for stream comparison
-	sortedShaSums := make([]string, 0, len(shaSums))
+	// map sha1sum back to secret names and fields for reporting. several
+	// secrets may share the same password.
+	shaSums := make(map[string][]location, len(pwList))
 	// display progress bar
 	bar := termio.NewProgressBar(int64(len(pwList)))
 	bar.Hidden = ctxutil.IsHidden(ctx)
 
-	fmt.Println("Computing SHA1 hashes of all your secrets ...")
+	s.optedOut = nil
+	s.checked = nil
+	s.errors = nil
+
+	fmt.Fprintln(s.logWriter(), "Computing SHA1 hashes of all your secrets ...")
 	for _, secret := range pwList {
 		// check for context cancelation
 		select {
@@ -116,50 +414,400 @@ func (s *hibp) precomputeHashes(ctx context.Context) (map[string]string, []strin
 
 		bar.Inc()
 
-		// only handle secrets / passwords, never the body
-		// comparing the body is super hard, as every user may choose to use
-		// the body of a secret differently. In the future we may support
-		// go templates to extract and compare data from the body
+		// only handle the password and the fields selected by the user.
+		// comparing the whole body is super hard, as every user may choose to
+		// use the body of a secret differently.
 		sec, err := s.gp.Get(ctx, secret, "latest")
 		if err != nil {
-			fmt.Printf("%s", "\n"+color.YellowString("Failed to retrieve secret '%s': %s\n", secret, err))
+			// the older revisions are not checked either
+			fmt.Fprintf(s.logWriter(), "%s", "\n"+color.YellowString("Failed to retrieve secret '%s': %s\n", secret, err))
+			s.errors = append(s.errors, reportError{Secret: secret, Error: err.Error()})
 
 			continue
 		}
 
-		pw := sec.Password()
-		// do not check empty passwords, there should be caught by `gopass audit`
-		// anyway
-		if len(pw) < 1 {
+		// the policy of the latest revision applies to all revisions
+		pol, err := readPolicy(sec)
+		if err != nil {
+			fmt.Fprintf(s.logWriter(), "%s", "\n"+color.YellowString("Ignoring the policy of secret '%s': %s\n", secret, err))
+			pol = policy{}
+		}
+		if pol.skip {
+			s.optedOut = append(s.optedOut, secret)
+
 			continue
 		}
-		sum := sha1hex(pw)
-		shaSums[sum] = secret
-		sortedShaSums = append(sortedShaSums, sum)
+		s.checked = append(s.checked, secret)
+
+		// field and hash pairs already seen in a newer revision
+		seen := make(map[[2]string]bool, 1)
+		s.addHashes(shaSums, seen, sec, location{Secret: secret, MaxCount: pol.maxCount})
+		if !s.history {
+			continue
+		}
+
+		for _, revision := range s.revisions(ctx, secret) {
+			sec, err := s.gp.Get(ctx, secret, revision)
+			if err != nil {
+				fmt.Fprintf(s.logWriter(), "%s", "\n"+color.YellowString("Failed to retrieve secret '%s' (revision %s): %s\n", secret, revision, err))
+				s.errors = append(s.errors, reportError{Secret: secret, Revision: revision, Error: err.Error()})
+
+				continue
+			}
+
+			s.addHashes(shaSums, seen, sec, location{
+				Secret:   secret,
+				Revision: revision,
+				MaxCount: pol.maxCount,
+			})
+		}
 	}
 	bar.Done()
-	// IMPORTANT: sort after all entries have been added. without the sort
-	// the stream compare will not work
+
+	// build list of distinct sha1sums for stream comparison
+	sortedShaSums := make([]string, 0, len(shaSums))
+	for sum := range shaSums {
+		sortedShaSums = append(sortedShaSums, sum)
+	}
+	// IMPORTANT: without the sort the stream compare will not work
 	sort.Strings(sortedShaSums)
 
 	return shaSums, sortedShaSums, nil
 }
 
-func (s *hibp) printMatches(matchList []string) error {
-	if len(matchList) < 1 {
-		fmt.Println("Good news - No matches found!")
+// addHashes adds the hashes of the password and the selected fields of a
+// revision of a secret at loc. Values already seen in a newer revision of the
+// secret are skipped.
+func (s *hibp) addHashes(shaSums map[string][]location, seen map[[2]string]bool, sec gopass.Secret, loc location) {
+	// empty values are never returned, empty passwords should be caught by
+	// `gopass audit` anyway
+	fields, err := s.fields.values(loc.Secret, sec)
+	if err != nil {
+		fmt.Fprintf(s.logWriter(), "%s", "\n"+color.YellowString("Failed to extract fields from secret '%s': %s\n", loc.Secret, err))
+		s.errors = append(s.errors, reportError{Secret: loc.Secret, Revision: loc.Revision, Error: err.Error()})
+	}
+	for field, values := range fields {
+		for _, value := range values {
+			sum := sha1hex(value)
+			key := [2]string{field, sum}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			loc.Field = field
+			shaSums[sum] = append(shaSums[sum], loc)
+		}
+	}
+}
+
+// revisions returns all revisions of a secret. Nothing is assumed about their
+// order, so the latest revision is usually read again. Its values are skipped
+// as already seen.
+func (s *hibp) revisions(ctx context.Context, secret string) []string {
+	revs, err := s.gp.Revisions(ctx, secret)
+	if err != nil {
+		fmt.Fprintf(s.logWriter(), "%s", "\n"+color.YellowString("Failed to list revisions of secret '%s': %s\n", secret, err))
+		s.errors = append(s.errors, reportError{Secret: secret, Error: "failed to list revisions: " + err.Error()})
 
 		return nil
 	}
 
-	sort.Strings(matchList)
-	fmt.Println("Oh no - Found some matches:")
-	for _, m := range matchList {
-		fmt.Printf("\t- %s\n", m)
+	return revs
+}
+
+// printMatches reports all secrets using one of the matched hashes in the
+// configured format. Rare matches, i.e. those seen less than minCount times,
+// are only reported as a warning and don't fail the run. Neither do matches
+// within the hibp-max-count of a secret, as given by counts, or acknowledged
+// matches.
+func (s *hibp) printMatches(ctx context.Context, backend string, sources []string, shaSums map[string][]location, counts map[string]uint64, matchList, probableList, rareList []string, minCount uint64) error {
+	r := s.newReport(backend, sources, shaSums, counts, matchList, probableList, rareList, minCount)
+	if err := s.writeReport(r); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := s.updateState(ctx, r); err != nil {
+		fmt.Fprintf(s.logWriter(), "%s", "\n"+color.YellowString("Failed to update the state of incremental checks: %s\n", err))
+	}
+
+	if r.failed() {
+		return errLeaks
+	}
+	if len(r.Errors) < 1 {
+		return nil
+	}
+
+	// errors without a secret are failures of the whole backend, e.g. a dump
+	// that could not be read
+	var problems []string
+	for _, e := range r.Errors {
+		if e.Secret == "" {
+			problems = append(problems, fmt.Sprintf("the %s backend failed: %s", backend, e.Error))
+		}
+	}
+	if r.Summary.Failed > 0 {
+		problems = append(problems, fmt.Sprintf("%d secrets could not be checked", r.Summary.Failed))
+	}
+
+	return fmt.Errorf("%w: %s", errIncomplete, strings.Join(problems, ", "))
+}
+
+// printGroups prints the secrets using each of the given hashes. Passwords
+// shared by several secrets are listed first. It returns true if any password
+// is reused by more than one secret. A value repeated in several fields or
+// revisions of the same secret is not considered reused.
+func printGroups(w io.Writer, shaSums map[string][]location, hashes []string, suffix string) bool {
+	type group struct {
+		names   []string
+		secrets int
+	}
+
+	groups := make([]group, 0, len(hashes))
+	for _, hash := range hashes {
+		names := make([]string, 0, len(shaSums[hash]))
+		secrets := make(map[string]bool, len(shaSums[hash]))
+		for _, loc := range shaSums[hash] {
+			names = append(names, loc.String())
+			secrets[loc.Secret] = true
+		}
+		if len(names) < 1 {
+			continue
+		}
+		sort.Strings(names)
+		groups = append(groups, group{names: names, secrets: len(secrets)})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].secrets != groups[j].secrets {
+			return groups[i].secrets > groups[j].secrets
+		}
+
+		return groups[i].names[0] < groups[j].names[0]
+	})
+
+	var reused bool
+	for _, g := range groups {
+		if g.secrets < 2 {
+			for _, name := range g.names {
+				fmt.Fprintf(w, "\t- %s%s\n", name, suffix)
+			}
+
+			continue
+		}
+
+		reused = true
+		fmt.Fprintln(w, color.RedString("\t- Password reused by %d secrets%s:", g.secrets, suffix))
+		for _, name := range g.names {
+			fmt.Fprintf(w, "\t\t- %s\n", name)
+		}
+	}
+
+	return reused
+}
+
+// logWriter returns the writer for progress and warnings.
+func (s *hibp) logWriter() io.Writer {
+	if s.log == nil {
+		return os.Stdout
 	}
-	fmt.Println("The passwords in the listed secrets were included in public leaks in the past. This means they are likely included in many word-list attacks and provide only very little security. Strongly consider changing those passwords!")
 
-	return fmt.Errorf("weak passwords found")
+	return s.log
+}
+
+// printScanStats prints a summary of malformed lines and returns an error if any
+// dump could not be scanned completely.
+func (s *hibp) printScanStats(stats []hibpdump.FileStats) error {
+	var failed int
+	for _, st := range stats {
+		if st.Err != nil {
+			fmt.Fprintln(s.logWriter(), color.RedString("Failed to check %s", st))
+			failed++
+
+			continue
+		}
+		if st.Malformed > 0 {
+			fmt.Fprintln(s.logWriter(), color.YellowString("Warning: %s. Use --strict to abort on malformed lines.", st))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to check %d dumps", failed)
+	}
+
+	return nil
+}
+
+// printDumpAges prints how old each dump is and warns if it is older than
+// maxAge. A maxAge of zero disables the warning.
+func (s *hibp) printDumpAges(dumps []string, maxAge time.Duration) {
+	for _, fn := range dumps {
+		created, err := hibpdump.Created(fn)
+		if err != nil {
+			debug.Log("failed to determine age of %s: %s", fn, err)
+
+			continue
+		}
+
+		days := int(time.Since(created).Hours() / 24)
+		if maxAge > 0 && time.Since(created) > maxAge {
+			fmt.Fprintln(s.logWriter(), color.YellowString("Warning: %s is %d days old (from %s). Consider downloading a new dump.", fn, days, created.Format("2006-01-02")))
+
+			continue
+		}
+		fmt.Fprintf(s.logWriter(), "Using %s from %s (%d days old)\n", fn, created.Format("2006-01-02"), days)
+	}
+}
+
+// verifyDumps checks the integrity of all given dumps and prints a report for
+// each of them.
+func verifyDumps(ctx context.Context, dumps []string) error {
+	if len(dumps) < 1 {
+		return fmt.Errorf("%w: need at least one dump file", errSetup)
+	}
+
+	var failed int
+	for _, fn := range dumps {
+		report, err := hibpdump.Verify(ctx, fn)
+		if err != nil {
+			fmt.Println(color.RedString("Failed to verify %s: %s", fn, err))
+			failed++
+
+			continue
+		}
+
+		fmt.Print(report)
+		problems := report.Problems()
+		if len(problems) < 1 {
+			fmt.Println(color.GreenString("OK"))
+			fmt.Println()
+
+			continue
+		}
+
+		failed++
+		for _, p := range problems {
+			fmt.Println(color.RedString("Problem: %s", p))
+		}
+		fmt.Println()
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dumps failed verification", failed, len(dumps))
+	}
+
+	return nil
+}
+
+// showDumpInfo reads all given dumps and prints statistics about each of
+// them.
+func showDumpInfo(ctx context.Context, dumps []string) error {
+	if len(dumps) < 1 {
+		return fmt.Errorf("%w: need at least one dump file", errSetup)
+	}
+
+	for _, fn := range dumps {
+		info, err := hibpdump.ReadInfo(ctx, fn)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", fn, err)
+		}
+		fmt.Println(info)
+	}
+
+	return nil
+}
+
+// findDumps returns the given dumps or, if there are none, the newest valid
+// dump in the dump directory.
+func findDumps(w io.Writer, dumps []string, dir string) ([]string, error) {
+	if len(dumps) > 0 {
+		return dumps, nil
+	}
+
+	newest, err := hibpdump.NewestInDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no dumps given and none found in the dump directory: %w", errSetup, err)
+	}
+	fmt.Fprintf(w, "Using the newest dump %s\n", newest)
+
+	return []string{newest}, nil
+}
+
+// listDumps prints all dumps in the dump directory.
+func listDumps(dir string) error {
+	files, err := hibpdump.ListDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(files) < 1 {
+		fmt.Printf("No dumps found in %s\n", dir)
+
+		return nil
+	}
+
+	for _, f := range files {
+		fmt.Printf("%s  %10s  %s\n", f.Created.Format("2006-01-02 15:04"), humanize.Bytes(uint64(f.Size)), f.Path)
+	}
+
+	return nil
+}
+
+// pruneDumps removes all but the newest keep dumps from the dump directory.
+func pruneDumps(dir string, keep int) error {
+	removed, err := hibpdump.PruneDir(dir, keep)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, f := range removed {
+		fmt.Printf("Removed %s (%s, %s)\n", f.Path, f.Created.Format("2006-01-02"), humanize.Bytes(uint64(f.Size)))
+	}
+	fmt.Printf("Removed %d dumps from %s\n", len(removed), dir)
+
+	return nil
+}
+
+// buildDump hashes every line of the plaintext wordlist input and writes the
+// hashes as a dump to output, e.g. to check against a list of banned passwords.
+func buildDump(ctx context.Context, input, output string) error {
+	fh, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer fh.Close() //nolint:errcheck
+
+	hashes := make([]string, 0, 1024)
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("user aborted")
+		default:
+		}
+
+		// passwords may start or end with spaces, don't trim them,
+		// but a CRLF line ending is never part of the word
+		word := strings.TrimRight(scanner.Text(), "\r")
+		if word == "" {
+			continue
+		}
+		hashes = append(hashes, sha1hex(word))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", input, err)
+	}
+
+	// the dump is always gzip compressed
+	if !strings.HasSuffix(output, ".gz") {
+		output += ".gz"
+	}
+	n, err := hibpdump.WriteDump(output, hashes, "wordlist "+input)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %d hashes of %d words to %s\n", n, len(hashes), output)
+
+	return nil
 }
 
 func sha1hex(data string) string {
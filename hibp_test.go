@@ -1,19 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 
 	hibpapi "github.com/gopasspw/gopass-hibp/pkg/hibp/api"
+	hibpdump "github.com/gopasspw/gopass-hibp/pkg/hibp/dump"
 	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/gopass"
+	"github.com/gopasspw/gopass/pkg/gopass/api"
 	"github.com/gopasspw/gopass/pkg/gopass/apimock"
+	"github.com/gopasspw/gopass/pkg/gopass/secrets"
 	"github.com/stretchr/testify/require"
 )
 
@@ -42,13 +50,55 @@ func TestHIBPDump(t *testing.T) {
 	fn := filepath.Join(dir, "dump.txt")
 
 	require.NoError(t, ioutil.WriteFile(fn, []byte(testHibpSample), 0o644))
-	require.NoError(t, act.CheckDump(ctx, false, []string{fn}))
+	require.NoError(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
 
 	// gzip
 	fn = filepath.Join(dir, "dump.txt.gz")
 
 	require.NoError(t, testWriteGZ(fn, []byte(testHibpSample)))
-	require.NoError(t, act.CheckDump(ctx, false, []string{fn}))
+	require.NoError(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+
+	// a leaked password, only a warning if it's rare
+	require.NoError(t, act.gp.Set(ctx, "baz", &apimock.Secret{Buf: []byte("foobar")}))
+	fn = filepath.Join(dir, "leaked.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSample+"\n8843D7F92416211DE9EBB963FF4CE28125932878:5\n"), 0o644))
+	require.Error(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+	require.Error(t, act.CheckDump(ctx, false, []string{fn}, 5, 0))
+	require.NoError(t, act.CheckDump(ctx, false, []string{fn}, 6, 0))
+
+	// never rare if a dump without counts has it too
+	v1 := filepath.Join(dir, "banned.txt")
+	require.NoError(t, os.WriteFile(v1, []byte("8843D7F92416211DE9EBB963FF4CE28125932878\n"), 0o644))
+	require.ErrorIs(t, act.CheckDump(ctx, false, []string{fn, v1}, 6, 0), errLeaks)
+}
+
+func TestHIBPFilter(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx := t.Context()
+	ctx = ctxutil.WithAlwaysYes(ctx, true)
+	ctx = ctxutil.WithHidden(ctx, true)
+
+	act := &hibp{
+		gp:  apimock.New(),
+		out: &bytes.Buffer{},
+	}
+	require.NoError(t, act.gp.Set(ctx, "baz", &apimock.Secret{Buf: []byte("foobar")}))
+
+	fn := filepath.Join(dir, "leaked.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSample+"\n8843D7F92416211DE9EBB963FF4CE28125932878:5\n"), 0o644))
+	scanner, err := hibpdump.New(fn)
+	require.NoError(t, err)
+	ffn := filepath.Join(dir, "filter")
+	require.NoError(t, scanner.BuildFilter(ctx, ffn, hibpdump.DefaultFalsePositiveRate, 0))
+	require.ErrorIs(t, act.CheckFilter(ctx, false, ffn, false, 0), errLeaks)
+
+	// lookups of a filter that can't be read are reported as errors
+	require.NoError(t, os.Truncate(ffn, 48))
+	act.errors = nil
+	require.ErrorIs(t, act.CheckFilter(ctx, false, ffn, false, 0), errIncomplete)
+	require.Len(t, act.errors, 1)
+	require.Equal(t, "baz", act.errors[0].Secret)
 }
 
 func testWriteGZ(fn string, buf []byte) error {
@@ -70,6 +120,22 @@ func testWriteGZ(fn string, buf []byte) error {
 	return err
 }
 
+func TestVerifyDumps(t *testing.T) {
+	dir := t.TempDir()
+	ctx := t.Context()
+
+	require.ErrorIs(t, verifyDumps(ctx, nil), errSetup)
+	require.ErrorIs(t, showDumpInfo(ctx, nil), errSetup)
+
+	fn := filepath.Join(dir, "dump.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSample), 0o644))
+	require.NoError(t, verifyDumps(ctx, []string{fn}))
+
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSample+"\n00000000A8DAE4228F821FB418F59826079BF368:42\n"), 0o644))
+	require.Error(t, verifyDumps(ctx, []string{fn}))
+	require.Error(t, verifyDumps(ctx, []string{filepath.Join(dir, "missing.txt")}))
+}
+
 func TestHIBPAPI(t *testing.T) {
 	ctx := t.Context()
 	ctx = ctxutil.WithAlwaysYes(ctx, true)
@@ -101,9 +167,247 @@ func TestHIBPAPI(t *testing.T) {
 	hibpapi.URL = ts.URL
 
 	// test with one entry
-	require.NoError(t, act.CheckAPI(ctx, false))
+	require.NoError(t, act.CheckAPI(ctx, false, 0))
 
 	// add another one
 	require.NoError(t, act.gp.Set(ctx, "baz", &apimock.Secret{Buf: []byte("foobar")}))
-	require.Error(t, act.CheckAPI(ctx, false))
+	require.Error(t, act.CheckAPI(ctx, false, 0))
+
+	// a match seen less often than required is only a warning
+	require.NoError(t, act.CheckAPI(ctx, false, 3234880))
+	require.Error(t, act.CheckAPI(ctx, false, 3234879))
+}
+
+func TestBuildDump(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx := t.Context()
+	ctx = ctxutil.WithAlwaysYes(ctx, true)
+
+	act := &hibp{
+		gp: apimock.New(),
+	}
+	require.NoError(t, act.gp.Set(ctx, "baz", &apimock.Secret{Buf: []byte("foobar")}))
+
+	in := filepath.Join(dir, "words.txt")
+	require.NoError(t, os.WriteFile(in, []byte("acme2024\r\n\nfoobar\n"), 0o644))
+	out := filepath.Join(dir, "banned.gz")
+	require.NoError(t, buildDump(ctx, in, out))
+	require.Error(t, buildDump(ctx, filepath.Join(dir, "missing.txt"), out))
+
+	// the banned password is found
+	require.Error(t, act.CheckDump(ctx, false, []string{out}, 0, 0))
+
+	// words from CRLF lines are found as well
+	crlf := &hibp{
+		gp: apimock.New(),
+	}
+	require.NoError(t, crlf.gp.Set(ctx, "qux", &apimock.Secret{Buf: []byte("acme2024")}))
+	require.ErrorIs(t, crlf.CheckDump(ctx, false, []string{out}, 0, 0), errLeaks)
+
+	// the dump is always gzip compressed
+	require.NoError(t, buildDump(ctx, in, filepath.Join(dir, "banned.txt")))
+	require.FileExists(t, filepath.Join(dir, "banned.txt.gz"))
+}
+
+func TestDumpDir(t *testing.T) {
+	dir := t.TempDir()
+
+	// explicit dumps are used as-is
+	dumps, err := findDumps(io.Discard, []string{"foo.txt"}, dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo.txt"}, dumps)
+
+	_, err = findDumps(io.Discard, nil, dir)
+	require.Error(t, err)
+	require.NoError(t, listDumps(filepath.Join(dir, "missing")))
+
+	fn := filepath.Join(dir, "dump.txt.gz")
+	require.NoError(t, testWriteGZ(fn, []byte(testHibpSample)))
+	dumps, err = findDumps(io.Discard, nil, dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{fn}, dumps)
+
+	require.NoError(t, listDumps(dir))
+	require.NoError(t, pruneDumps(dir, 0))
+	require.NoFileExists(t, fn)
+}
+
+func TestPrecomputeHashes(t *testing.T) {
+	ctx := t.Context()
+	ctx = ctxutil.WithHidden(ctx, true)
+
+	act := &hibp{
+		gp: apimock.New(),
+	}
+	for name, pw := range map[string]string{
+		"web/a": "foobar",
+		"web/b": "foobar",
+		"web/c": "secret",
+		"empty": "",
+	} {
+		require.NoError(t, act.gp.Set(ctx, name, &apimock.Secret{Buf: []byte(pw)}))
+	}
+
+	shaSums, sortedShaSums, err := act.precomputeHashes(ctx, testList(t, act))
+	require.NoError(t, err)
+
+	foobar := sha1hex("foobar")
+	// every hash is only looked up once
+	require.Len(t, sortedShaSums, 2)
+	require.Contains(t, sortedShaSums, foobar)
+	// but all secrets sharing the password are reported
+	require.ElementsMatch(t, []location{
+		{Secret: "web/a", Field: fieldPassword},
+		{Secret: "web/b", Field: fieldPassword},
+	}, shaSums[foobar])
+
+	require.True(t, printGroups(io.Discard, shaSums, []string{foobar}, ""))
+	require.False(t, printGroups(io.Discard, shaSums, []string{sha1hex("secret")}, ""))
+
+	// several fields and revisions of a single secret are no reuse
+	buf := &bytes.Buffer{}
+	require.False(t, printGroups(buf, map[string][]location{foobar: {
+		{Secret: "web/a", Field: fieldPassword},
+		{Secret: "web/a", Field: "pin"},
+		{Secret: "web/a", Field: fieldPassword, Revision: "1"},
+	}}, []string{foobar}, ""))
+	require.NotContains(t, buf.String(), "reused")
+	require.Contains(t, buf.String(), "web/a (field pin)")
+	buf.Reset()
+	require.True(t, printGroups(buf, map[string][]location{foobar: {
+		{Secret: "web/a", Field: fieldPassword},
+		{Secret: "web/a", Field: "pin"},
+		{Secret: "web/b", Field: fieldPassword},
+	}}, []string{foobar}, ""))
+	require.Contains(t, buf.String(), "Password reused by 2 secrets")
+
+	// only the selected secrets are decrypted
+	sel, err := newSelector([]string{"web"}, nil, []string{"web/c"})
+	require.NoError(t, err)
+	act.selector = sel
+	pwList, err := act.list(ctx)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"web/a", "web/b"}, pwList)
+	_, sortedShaSums, err = act.precomputeHashes(ctx, pwList)
+	require.NoError(t, err)
+	require.Equal(t, []string{foobar}, sortedShaSums)
+
+	act.selector, err = newSelector([]string{"db"}, nil, nil)
+	require.NoError(t, err)
+	_, err = act.list(ctx)
+	require.Error(t, err)
+}
+
+func testList(t *testing.T, act *hibp) []string {
+	t.Helper()
+
+	pwList, err := act.list(t.Context())
+	require.NoError(t, err)
+
+	return pwList
+}
+
+// historyStore is a gopass store with a history of revisions per secret.
+// Revisions is nil for stores that don't support revisions.
+type historyStore struct {
+	*apimock.MockAPI
+
+	history map[string][]string
+	broken  map[string]bool
+	// gets counts the calls to Get per secret and revision
+	gets map[string]int
+}
+
+func (h *historyStore) Revisions(_ context.Context, name string) ([]string, error) {
+	if h.history == nil {
+		return nil, api.ErrNotImplemented
+	}
+
+	revs := make([]string, 0, len(h.history[name]))
+	for i := range h.history[name] {
+		revs = append(revs, strconv.Itoa(i))
+	}
+
+	return revs, nil
+}
+
+func (h *historyStore) Get(ctx context.Context, name, revision string) (gopass.Secret, error) {
+	if h.gets == nil {
+		h.gets = make(map[string]int, 1)
+	}
+	h.gets[name+"@"+revision]++
+	if h.broken[name] {
+		return nil, fmt.Errorf("failed to decrypt %s", name)
+	}
+	if revision == "latest" {
+		return h.MockAPI.Get(ctx, name, revision)
+	}
+
+	i, err := strconv.Atoi(revision)
+	if err != nil || i >= len(h.history[name]) {
+		return nil, fmt.Errorf("unknown revision %q", revision)
+	}
+
+	return secrets.ParseAKV([]byte(h.history[name][i])), nil
+}
+
+func TestHistory(t *testing.T) {
+	ctx := t.Context()
+	ctx = ctxutil.WithHidden(ctx, true)
+
+	// the revisions are in no particular order
+	gp := &historyStore{
+		MockAPI: apimock.New(),
+		history: map[string][]string{
+			"web/a": {"foobar", "current", "current"},
+			"web/b": {"broken", "foobar"},
+		},
+		broken: map[string]bool{"web/b": true},
+	}
+	require.NoError(t, gp.Set(ctx, "web/a", &apimock.Secret{Buf: []byte("current")}))
+	require.NoError(t, gp.Set(ctx, "web/b", &apimock.Secret{Buf: []byte("broken")}))
+
+	act := &hibp{gp: gp}
+	shaSums, sortedShaSums, err := act.precomputeHashes(ctx, testList(t, act))
+	require.NoError(t, err)
+	require.Len(t, sortedShaSums, 1)
+
+	act.history = true
+	gp.gets = nil
+	shaSums, sortedShaSums, err = act.precomputeHashes(ctx, testList(t, act))
+	require.NoError(t, err)
+	require.Len(t, sortedShaSums, 2)
+	// the current password is only reported once
+	require.Equal(t, []location{{Secret: "web/a", Field: fieldPassword}}, shaSums[sha1hex("current")])
+	require.Equal(t, []location{{Secret: "web/a", Field: fieldPassword, Revision: "0"}}, shaSums[sha1hex("foobar")])
+	require.Equal(t, "web/a (revision 0)", shaSums[sha1hex("foobar")][0].String())
+	require.Equal(t, map[string]int{
+		"web/a@latest": 1,
+		"web/a@0":      1,
+		"web/a@1":      1,
+		"web/a@2":      1,
+		"web/b@latest": 1,
+	}, gp.gets)
+	// no history is checked if the latest revision fails
+	require.Equal(t, []reportError{{Secret: "web/b", Error: "failed to decrypt web/b"}}, act.errors)
+
+	// the prompt counts every revision and the secrets of gopass-hibp
+	require.Equal(t, "2 secrets and 5 revisions of them", act.unlockText(ctx, testList(t, act)))
+	require.NoError(t, gp.Set(ctx, ignoreSecret, &apimock.Secret{Buf: []byte("")}))
+	require.NoError(t, gp.Set(ctx, keySecret, &apimock.Secret{Buf: []byte("key")}))
+	require.NoError(t, gp.Set(ctx, stateSecret, &apimock.Secret{Buf: []byte("{}")}))
+	require.Equal(t, "2 secrets, 5 revisions of them and 2 secrets of gopass-hibp", act.unlockText(ctx, testList(t, act)))
+	act.incremental = true
+	act.history = false
+	require.Equal(t, "2 secrets and 3 secrets of gopass-hibp", act.unlockText(ctx, testList(t, act)))
+	act.history = true
+	act.incremental = false
+
+	// stores without revisions fail before anything is decrypted
+	gp.history = nil
+	gp.gets = nil
+	_, err = act.list(ctx)
+	require.ErrorIs(t, err, errSetup)
+	require.Empty(t, gp.gets)
 }
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/gopasspw/gopass/pkg/gopass"
+	"github.com/gopasspw/gopass/pkg/gopass/secrets"
+)
+
+const (
+	// internalPrefix holds the secrets used by gopass-hibp itself. They are
+	// never checked.
+	internalPrefix = "gopass-hibp/"
+	// ignoreSecret is the ignore list kept in the store.
+	ignoreSecret = internalPrefix + "ignore"
+	// keySecret holds the key used to compute HMACs of hashes.
+	keySecret = internalPrefix + "key"
+	// hmacPrefix marks ignore entries matching a HMAC of a hash instead of
+	// a secret name.
+	hmacPrefix = "hmac:"
+	// expiryLayout is the format of the expiry dates.
+	expiryLayout = "2006-01-02"
+)
+
+// ignoreEntry acknowledges the leaks of the matching secrets until it expires.
+type ignoreEntry struct {
+	// Target is a secret name, a glob matching secret names or a HMAC of a
+	// password hash prefixed with "hmac:".
+	Target string
+	// Expires is the last day the entry is valid.
+	Expires time.Time
+	Reason  string
+	// Source is the file or secret the entry was read from.
+	Source string
+}
+
+func (e *ignoreEntry) expired(now time.Time) bool {
+	return !now.Before(e.Expires.AddDate(0, 0, 1))
+}
+
+// String returns the entry in the format of the ignore list.
+func (e *ignoreEntry) String() string {
+	return fmt.Sprintf("%s %s %s", e.Target, e.Expires.Format(expiryLayout), e.Reason)
+}
+
+// ignoreList holds the acknowledged leaks. Each line of an ignore list has the
+// form "<target> <expiry date> <reason>", lines starting with "#" are comments.
+type ignoreList struct {
+	entries []*ignoreEntry
+	// key is used to compute the HMACs of hashes. It is only loaded if
+	// there are HMAC entries.
+	key []byte
+}
+
+// acknowledgement is a match covered by an ignore entry.
+type acknowledgement struct {
+	loc   location
+	sum   string
+	entry *ignoreEntry
+}
+
+func parseIgnoreList(r io.Reader, source string) ([]*ignoreEntry, error) {
+	var entries []*ignoreEntry
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: need a target, an expiry date and a reason", source, lineNo)
+		}
+		entry, err := newIgnoreEntry(fields[0], fields[1], strings.Join(fields[2:], " "))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", source, lineNo, err)
+		}
+		entry.Source = source
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func newIgnoreEntry(target, expires, reason string) (*ignoreEntry, error) {
+	if strings.HasPrefix(target, hmacPrefix) {
+		if _, err := hex.DecodeString(strings.TrimPrefix(target, hmacPrefix)); err != nil {
+			return nil, fmt.Errorf("invalid HMAC %q: %w", target, err)
+		}
+	} else if _, err := path.Match(target, ""); err != nil {
+		return nil, fmt.Errorf("invalid secret pattern %q: %w", target, err)
+	}
+
+	exp, err := time.ParseInLocation(expiryLayout, expires, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiry date %q: %w", expires, err)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, fmt.Errorf("missing reason for %q", target)
+	}
+
+	return &ignoreEntry{
+		Target:  target,
+		Expires: exp,
+		Reason:  strings.TrimSpace(reason),
+	}, nil
+}
+
+// loadIgnoreList reads the ignore list from the store and from the given file.
+// Both are optional.
+func loadIgnoreList(ctx context.Context, w io.Writer, gp gopass.Store, file string) (*ignoreList, error) {
+	l := &ignoreList{}
+
+	sec, err := readSecret(ctx, gp, ignoreSecret)
+	if err != nil {
+		return nil, err
+	}
+	if sec != nil {
+		entries, err := parseIgnoreList(bytes.NewReader(sec.Bytes()), ignoreSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ignore list: %w", err)
+		}
+		l.entries = append(l.entries, entries...)
+	}
+
+	if file != "" {
+		buf, err := os.ReadFile(file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read ignore list: %w", err)
+		}
+		entries, err := parseIgnoreList(bytes.NewReader(buf), file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ignore list: %w", err)
+		}
+		l.entries = append(l.entries, entries...)
+	}
+
+	if !slices.ContainsFunc(l.entries, func(e *ignoreEntry) bool { return strings.HasPrefix(e.Target, hmacPrefix) }) {
+		return l, nil
+	}
+
+	key, err := readSecret(ctx, gp, keySecret)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || key.Password() == "" {
+		fmt.Fprintln(w, color.YellowString("Warning: The ignore list contains HMACs but the key %s is missing. They will not match.", keySecret))
+
+		return l, nil
+	}
+	l.key = []byte(key.Password())
+
+	return l, nil
+}
+
+// readSecret returns a secret or nil if it doesn't exist. The store is only
+// listed if the secret can't be read, to tell a missing secret from a broken one.
+func readSecret(ctx context.Context, gp gopass.Store, name string) (gopass.Secret, error) {
+	sec, err := gp.Get(ctx, name, "latest")
+	if err == nil {
+		return sec, nil
+	}
+
+	names, lerr := gp.List(ctx)
+	if lerr != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", lerr)
+	}
+	if !slices.Contains(names, name) {
+		return nil, nil //nolint:nilnil
+	}
+
+	return nil, fmt.Errorf("failed to read %s: %w", name, err)
+}
+
+// hashKey returns the HMAC key, creating a new random key in the store if
+// there is none.
+func hashKey(ctx context.Context, w io.Writer, gp gopass.Store) ([]byte, error) {
+	sec, err := readSecret(ctx, gp, keySecret)
+	if err != nil {
+		return nil, err
+	}
+	if sec != nil && sec.Password() != "" {
+		return []byte(sec.Password()), nil
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to create key: %w", err)
+	}
+	key := []byte(hex.EncodeToString(buf))
+	if err := gp.Set(ctx, keySecret, secrets.ParseAKV(key)); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", keySecret, err)
+	}
+	fmt.Fprintf(w, "Created a new key in %s\n", keySecret)
+
+	return key, nil
+}
+
+// hashMAC returns the HMAC of a password hash. Unlike the hash itself it can't
+// be looked up in a dump without the key.
+func hashMAC(key []byte, sum string) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(strings.ToUpper(sum)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// match returns the first entry matching the location or the hash. Valid
+// entries are preferred over expired ones.
+func (l *ignoreList) match(loc location, sum string, now time.Time) *ignoreEntry {
+	if l == nil {
+		return nil
+	}
+
+	var mac string
+	if len(l.key) > 0 {
+		mac = hmacPrefix + hashMAC(l.key, sum)
+	}
+
+	var found *ignoreEntry
+	for _, e := range l.entries {
+		if strings.HasPrefix(e.Target, hmacPrefix) {
+			if mac == "" || !hmac.Equal([]byte(strings.ToLower(e.Target)), []byte(mac)) {
+				continue
+			}
+		} else if !matchAny([]string{strings.Trim(e.Target, "/")}, loc.Secret) {
+			continue
+		}
+
+		if !e.expired(now) {
+			return e
+		}
+		if found == nil {
+			found = e
+		}
+	}
+
+	return found
+}
+
+// apply removes the acknowledged locations of the given hashes from shaSums.
+// The input map is not modified. Locations matched by expired entries are kept
+// and returned separately.
+func (l *ignoreList) apply(shaSums map[string][]location, hashes []string, now time.Time) (map[string][]location, []acknowledgement, []acknowledgement) {
+	if l == nil || len(l.entries) < 1 {
+		return shaSums, nil, nil
+	}
+
+	out := make(map[string][]location, len(shaSums))
+	for sum, locs := range shaSums {
+		out[sum] = locs
+	}
+
+	var acked, expired []acknowledgement
+	for _, sum := range hashes {
+		open := make([]location, 0, len(shaSums[sum]))
+		for _, loc := range shaSums[sum] {
+			e := l.match(loc, sum, now)
+			switch {
+			case e == nil:
+				open = append(open, loc)
+			case e.expired(now):
+				open = append(open, loc)
+				expired = append(expired, acknowledgement{loc: loc, sum: sum, entry: e})
+			default:
+				acked = append(acked, acknowledgement{loc: loc, sum: sum, entry: e})
+			}
+		}
+		out[sum] = open
+	}
+
+	return out, acked, expired
+}
+
+// addIgnoreEntry appends an entry to the ignore list in the store or in the
+// given file.
+func addIgnoreEntry(ctx context.Context, gp gopass.Store, file string, inStore bool, entry *ignoreEntry) error {
+	line := entry.String() + "\n"
+
+	if inStore {
+		sec, err := readSecret(ctx, gp, ignoreSecret)
+		if err != nil {
+			return err
+		}
+		var buf []byte
+		if sec != nil {
+			buf = sec.Bytes()
+		}
+		if len(buf) < 1 {
+			buf = []byte("# <secret, glob or hmac:...> <expires YYYY-MM-DD> <reason>\n")
+		}
+		if !bytes.HasSuffix(buf, []byte("\n")) {
+			buf = append(buf, '\n')
+		}
+		buf = append(buf, line...)
+		if err := gp.Set(ctx, ignoreSecret, secrets.ParseAKV(buf)); err != nil {
+			return fmt.Errorf("failed to write %s: %w", ignoreSecret, err)
+		}
+		fmt.Printf("Added %q to %s\n", entry.Target, ignoreSecret)
+
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(file), err)
+	}
+	fh, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", file, err)
+	}
+	defer fh.Close() //nolint:errcheck
+
+	if _, err := fh.WriteString(line); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	fmt.Printf("Added %q to %s\n", entry.Target, file)
+
+	return fh.Close()
+}
+
+// printIgnoreList prints all entries and whether they are still valid.
+func printIgnoreList(l *ignoreList, now time.Time) {
+	if len(l.entries) < 1 {
+		fmt.Println("The ignore list is empty")
+
+		return
+	}
+
+	for _, e := range l.entries {
+		state := "valid"
+		if e.expired(now) {
+			state = color.YellowString("expired")
+		}
+		fmt.Printf("%s  %s  %-8s  %s (%s)\n", e.Expires.Format(expiryLayout), e.Target, state, e.Reason, e.Source)
+	}
+}
+
+// listIgnored prints the ignore lists in the store and in the ignore file.
+func (s *hibp) listIgnored(ctx context.Context) error {
+	l, err := loadIgnoreList(ctx, s.logWriter(), s.gp, s.ignoreFile)
+	if err != nil {
+		return err
+	}
+	printIgnoreList(l, time.Now())
+
+	return nil
+}
+
+// addIgnored acknowledges the leak of a secret. If hash is set the password of
+// the secret is acknowledged instead, for every secret using it. Only a HMAC
+// of its hash is written to the ignore list.
+func (s *hibp) addIgnored(ctx context.Context, target string, hash, inStore bool, expires, reason string) error {
+	if hash {
+		sec, err := s.gp.Get(ctx, target, "latest")
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", target, err)
+		}
+		if sec.Password() == "" {
+			return fmt.Errorf("%s has no password", target)
+		}
+		key, err := hashKey(ctx, s.logWriter(), s.gp)
+		if err != nil {
+			return err
+		}
+		target = hmacPrefix + hashMAC(key, sha1hex(sec.Password()))
+	}
+
+	entry, err := newIgnoreEntry(target, expires, reason)
+	if err != nil {
+		return err
+	}
+
+	return addIgnoreEntry(ctx, s.gp, s.ignoreFile, inStore, entry)
+}
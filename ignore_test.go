@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/gopass/apimock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoreList(t *testing.T) {
+	entries, err := parseIgnoreList(strings.NewReader("# comment\n"+
+		"\n"+
+		"demo/login 2026-12-31 shared demo account\n"+
+		"test/* 2026-01-31   test fixtures\n"), "ignore")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "demo/login", entries[0].Target)
+	assert.Equal(t, "shared demo account", entries[0].Reason)
+	assert.Equal(t, "test/* 2026-01-31 test fixtures", entries[1].String())
+
+	for _, in := range []string{
+		"demo/login 2026-12-31\n",
+		"demo/login 31.12.2026 shared demo account\n",
+		"demo/[ 2026-12-31 shared demo account\n",
+		"hmac:xyz 2026-12-31 shared demo account\n",
+	} {
+		_, err := parseIgnoreList(strings.NewReader(in), "ignore")
+		require.Error(t, err, in)
+	}
+
+	key := []byte("key")
+	sum := sha1hex("foobar")
+	mac := &ignoreEntry{Target: hmacPrefix + hashMAC(key, sum), Expires: entries[0].Expires, Reason: "default password"}
+	l := &ignoreList{entries: append(entries, mac), key: key}
+
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.Local)
+	assert.Equal(t, entries[0], l.match(location{Secret: "demo/login"}, sum, now))
+	assert.Nil(t, l.match(location{Secret: "demo/login2"}, sha1hex("other"), now))
+	assert.Equal(t, mac, l.match(location{Secret: "web/a"}, sum, now))
+	// expired entries are still returned
+	assert.Equal(t, entries[1], l.match(location{Secret: "test/ci/login"}, sha1hex("other"), now))
+	assert.True(t, entries[1].expired(now))
+	// the entry is valid until the end of the day
+	assert.False(t, entries[0].expired(time.Date(2026, 12, 31, 23, 59, 0, 0, time.Local)))
+	assert.True(t, entries[0].expired(time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local)))
+
+	shaSums := map[string][]location{
+		sum:              {{Secret: "demo/login"}, {Secret: "web/b"}},
+		sha1hex("other"): {{Secret: "test/login"}, {Secret: "web/c"}},
+	}
+	l.entries = entries
+	out, acked, expired := l.apply(shaSums, []string{sum, sha1hex("other")}, now)
+	assert.Equal(t, []location{{Secret: "web/b"}}, out[sum])
+	assert.Equal(t, []location{{Secret: "test/login"}, {Secret: "web/c"}}, out[sha1hex("other")])
+	require.Len(t, acked, 1)
+	assert.Equal(t, "demo/login", acked[0].loc.Secret)
+	require.Len(t, expired, 1)
+	assert.Equal(t, "test/login", expired[0].loc.Secret)
+	// the input is not modified
+	assert.Len(t, shaSums[sum], 2)
+}
+
+func TestHIBPDumpIgnored(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx := t.Context()
+	ctx = ctxutil.WithAlwaysYes(ctx, true)
+	ctx = ctxutil.WithHidden(ctx, true)
+
+	act := &hibp{
+		gp:         apimock.New(),
+		ignoreFile: filepath.Join(dir, "config", "ignore"),
+	}
+	require.NoError(t, act.gp.Set(ctx, "demo/login", &apimock.Secret{Buf: []byte("foobar")}))
+
+	fn := filepath.Join(dir, "leaked.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSample+"\n8843D7F92416211DE9EBB963FF4CE28125932878:5\n"), 0o644))
+	require.Error(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+
+	// acknowledged in the ignore file
+	tomorrow := time.Now().AddDate(0, 0, 1).Format(expiryLayout)
+	require.NoError(t, act.addIgnored(ctx, "demo/*", false, false, tomorrow, "shared demo account"))
+	require.NoError(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+
+	// but not for other secrets using the same password
+	require.NoError(t, act.gp.Set(ctx, "web/login", &apimock.Secret{Buf: []byte("foobar")}))
+	require.Error(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+
+	// unless the password itself is acknowledged, the ignore list and its key
+	// are never checked
+	require.NoError(t, act.addIgnored(ctx, "web/login", true, true, tomorrow, "default password"))
+	require.NoError(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+	sec, err := act.gp.Get(ctx, ignoreSecret, "latest")
+	require.NoError(t, err)
+	assert.Contains(t, string(sec.Bytes()), hmacPrefix)
+	assert.NotContains(t, string(sec.Bytes()), sha1hex("foobar"))
+
+	// expired entries fail again
+	require.NoError(t, os.WriteFile(act.ignoreFile, []byte("demo/login 2020-01-01 shared demo account\n"), 0o600))
+	require.NoError(t, act.gp.Remove(ctx, ignoreSecret))
+	require.NoError(t, act.gp.Remove(ctx, "web/login"))
+	require.Error(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+}
+
+// listCountStore counts how often the store is listed.
+type listCountStore struct {
+	*apimock.MockAPI
+
+	lists int
+}
+
+func (l *listCountStore) List(ctx context.Context) ([]string, error) {
+	l.lists++
+
+	return l.MockAPI.List(ctx)
+}
+
+func TestReadSecret(t *testing.T) {
+	ctx := t.Context()
+
+	gp := &listCountStore{MockAPI: apimock.New()}
+	require.NoError(t, gp.Set(ctx, ignoreSecret, &apimock.Secret{Buf: []byte("\n")}))
+
+	sec, err := readSecret(ctx, gp, ignoreSecret)
+	require.NoError(t, err)
+	assert.NotNil(t, sec)
+	assert.Equal(t, 0, gp.lists)
+
+	// the store is only listed to find out if the secret is missing
+	sec, err = readSecret(ctx, gp, keySecret)
+	require.NoError(t, err)
+	assert.Nil(t, sec)
+	assert.Equal(t, 1, gp.lists)
+
+	broken := &brokenStore{MockAPI: gp.MockAPI, broken: map[string]bool{ignoreSecret: true}}
+	_, err = readSecret(ctx, broken, ignoreSecret)
+	require.Error(t, err)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+	hibpdump "github.com/gopasspw/gopass-hibp/pkg/hibp/dump"
+	"github.com/gopasspw/gopass/pkg/gopass/secrets"
+)
+
+const (
+	// stateSecret holds the state of incremental checks. It is encrypted like
+	// every other secret.
+	stateSecret = internalPrefix + "state"
+	// stateVersion is increased on incompatible changes of the state.
+	stateVersion = 2
+)
+
+// checkState holds the fingerprints of all secrets that were checked without
+// any match, per backend and sources, see sourceKey.
+type checkState struct {
+	Version int                     `json:"version"`
+	Sources map[string]*sourceState `json:"sources"`
+}
+
+// sourceState holds the secrets checked without any match against the same
+// sources, e.g. the same dumps.
+type sourceState struct {
+	// Digest identifies the content of the sources. All secrets are checked
+	// again once it changes.
+	Digest  string                `json:"digest"`
+	Entries map[string]stateEntry `json:"entries"`
+}
+
+// stateEntry is a secret that was checked without any match.
+type stateEntry struct {
+	// Fingerprint is a HMAC of all values checked.
+	Fingerprint string    `json:"fingerprint"`
+	Checked     time.Time `json:"checked"`
+}
+
+func loadState(ctx context.Context, s *hibp) (*checkState, error) {
+	st := &checkState{
+		Version: stateVersion,
+		Sources: make(map[string]*sourceState, 1),
+	}
+
+	sec, err := readSecret(ctx, s.gp, stateSecret)
+	if err != nil || sec == nil {
+		return st, err
+	}
+
+	if err := json.Unmarshal(sec.Bytes(), st); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", stateSecret, err)
+	}
+	if st.Version != stateVersion {
+		fmt.Fprintln(s.logWriter(), color.YellowString("Warning: Ignoring %s of an unsupported version %d", stateSecret, st.Version))
+
+		return &checkState{Version: stateVersion, Sources: make(map[string]*sourceState, 1)}, nil
+	}
+	if st.Sources == nil {
+		st.Sources = make(map[string]*sourceState, 1)
+	}
+
+	return st, nil
+}
+
+func (st *checkState) save(ctx context.Context, s *hibp) error {
+	buf, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	if err := s.gp.Set(ctx, stateSecret, secrets.ParseAKV(append(buf, '\n'))); err != nil {
+		return fmt.Errorf("failed to write %s: %w", stateSecret, err)
+	}
+
+	return nil
+}
+
+// fingerprints returns a HMAC of the checked values of each secret. It changes
+// whenever a value or the selection of fields changes.
+func fingerprints(key []byte, shaSums map[string][]location) map[string]string {
+	values := make(map[string][]string, len(shaSums))
+	for sum, locs := range shaSums {
+		for _, loc := range locs {
+			values[loc.Secret] = append(values[loc.Secret], loc.Field+"\x00"+loc.Revision+"\x00"+sum)
+		}
+	}
+
+	fps := make(map[string]string, len(values))
+	for name, vals := range values {
+		sort.Strings(vals)
+		mac := hmac.New(sha256.New, key)
+		_, _ = mac.Write([]byte(name))
+		for _, v := range vals {
+			_, _ = mac.Write([]byte("\n" + v))
+		}
+		fps[name] = hex.EncodeToString(mac.Sum(nil))
+	}
+
+	return fps
+}
+
+// sourceKey identifies a backend and its sources, e.g. the dumps or the filter,
+// by their paths. The digest changes with the content of the sources, i.e.
+// their size, modification time and the checksum in their metadata.
+func sourceKey(backend string, sources []string) (string, string) {
+	if len(sources) < 1 {
+		return backend, ""
+	}
+
+	paths := make([]string, 0, len(sources))
+	for _, fn := range sources {
+		if abs, err := filepath.Abs(fn); err == nil {
+			fn = abs
+		}
+		paths = append(paths, fn)
+	}
+	sort.Strings(paths)
+
+	key := sha256.New()
+	digest := sha256.New()
+	for _, fn := range paths {
+		_, _ = fmt.Fprintf(key, "%s\x00", fn)
+		_, _ = fmt.Fprintf(digest, "%s\x00", fn)
+		if fi, err := os.Stat(fn); err == nil {
+			_, _ = fmt.Fprintf(digest, "%d\x00%d\x00", fi.Size(), fi.ModTime().UnixNano())
+		}
+		if meta, err := hibpdump.ReadMetadata(fn); err == nil {
+			_, _ = fmt.Fprintf(digest, "%s\x00", meta.SHA256)
+		}
+	}
+
+	return backend + ":" + hex.EncodeToString(key.Sum(nil))[:16], hex.EncodeToString(digest.Sum(nil))
+}
+
+// dumpsCreated returns when the newest of the dumps was created. If that is
+// unknown for any dump it returns the current time, so all secrets are
+// checked again.
+func dumpsCreated(dumps []string) time.Time {
+	var newest time.Time
+	for _, fn := range dumps {
+		created, err := hibpdump.Created(fn)
+		if err != nil {
+			return time.Now()
+		}
+		if created.After(newest) {
+			newest = created
+		}
+	}
+
+	return newest
+}
+
+// fileModified returns the modification time of a file or the current time if
+// it is unknown.
+func fileModified(fn string) time.Time {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return time.Now()
+	}
+
+	return fi.ModTime()
+}
+
+// skipUnchanged returns the hashes to look up, leaving out those only used by
+// secrets whose values didn't change since they were last checked against the
+// same sources without any match, unless the data of the backend changed since
+// then, i.e. it is newer than dataTime. The secrets are decrypted anyway, their
+// fingerprints need the current values. shaSums is not modified, so hashes
+// shared with a secret that is looked up are still reported for all secrets.
+func (s *hibp) skipUnchanged(ctx context.Context, backend string, sources []string, dataTime time.Time, shaSums map[string][]location) ([]string, error) {
+	key, err := hashKey(ctx, s.logWriter(), s.gp)
+	if err != nil {
+		return nil, err
+	}
+	if s.state, err = loadState(ctx, s); err != nil {
+		return nil, err
+	}
+
+	srcKey, digest := sourceKey(backend, sources)
+	s.source = s.state.Sources[srcKey]
+	if s.source == nil || s.source.Digest != digest {
+		s.source = &sourceState{Digest: digest, Entries: make(map[string]stateEntry, len(shaSums))}
+		s.state.Sources[srcKey] = s.source
+	}
+
+	s.fingerprints = fingerprints(key, shaSums)
+	s.unchanged = make(map[string]bool, len(s.fingerprints))
+	for name, fp := range s.fingerprints {
+		e, found := s.source.Entries[name]
+		if found && hmac.Equal([]byte(e.Fingerprint), []byte(fp)) && e.Checked.After(dataTime) {
+			s.unchanged[name] = true
+		}
+	}
+
+	sortedShaSums := make([]string, 0, len(shaSums))
+	for sum, locs := range shaSums {
+		if slices.ContainsFunc(locs, func(loc location) bool { return !s.unchanged[loc.Secret] }) {
+			sortedShaSums = append(sortedShaSums, sum)
+		}
+	}
+	sort.Strings(sortedShaSums)
+
+	if len(s.unchanged) > 0 {
+		fmt.Fprintf(s.logWriter(), "Skipping the lookup of %d unchanged secrets checked after %s\n", len(s.unchanged), dataTime.Format(time.DateTime))
+	}
+
+	return sortedShaSums, nil
+}
+
+// updateState records the secrets checked without any match and forgets all
+// others. The state is only written if it changed.
+func (s *hibp) updateState(ctx context.Context, r *report) error {
+	if s.state == nil || s.source == nil {
+		return nil
+	}
+
+	dirty := make(map[string]bool, len(r.Matches)+len(r.Errors))
+	for _, m := range r.Matches {
+		dirty[m.Secret] = true
+	}
+	// an error of the backend, e.g. an unreadable dump, affects every secret
+	failedBackend := false
+	for _, e := range r.Errors {
+		dirty[e.Secret] = true
+		if e.Secret == "" {
+			failedBackend = true
+		}
+	}
+
+	entries := s.source.Entries
+	if entries == nil {
+		entries = make(map[string]stateEntry, len(s.fingerprints))
+		s.source.Entries = entries
+	}
+	changed := false
+	for name, fp := range s.fingerprints {
+		prev, found := entries[name]
+		// unchanged secrets weren't looked up, but they can still share a
+		// hash with one that matched
+		if s.unchanged[name] && !dirty[name] {
+			continue
+		}
+		if dirty[name] || failedBackend {
+			if found {
+				delete(entries, name)
+				changed = true
+			}
+
+			continue
+		}
+		if found && prev.Fingerprint == fp && prev.Checked.Equal(r.Finished) {
+			continue
+		}
+		entries[name] = stateEntry{Fingerprint: fp, Checked: r.Finished}
+		changed = true
+	}
+
+	// don't add a commit to the store if nothing changed
+	if !changed {
+		return nil
+	}
+
+	return s.state.save(ctx, s)
+}
+
+// incrementalCheck returns the hashes to look up. If incremental checks are
+// enabled these are filtered by skipUnchanged.
+func (s *hibp) incrementalCheck(ctx context.Context, backend string, sources []string, dataTime time.Time, shaSums map[string][]location, sortedShaSums []string) ([]string, error) {
+	s.state = nil
+	s.source = nil
+	s.unchanged = nil
+	s.fingerprints = nil
+	if !s.incremental {
+		return sortedShaSums, nil
+	}
+
+	return s.skipUnchanged(ctx, backend, sources, dataTime, shaSums)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	hibpapi "github.com/gopasspw/gopass-hibp/pkg/hibp/api"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/gopass"
+	"github.com/gopasspw/gopass/pkg/gopass/apimock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprints(t *testing.T) {
+	key := []byte("key")
+	shaSums := map[string][]location{
+		sha1hex("foobar"): {{Secret: "web/a", Field: fieldPassword}},
+		sha1hex("secret"): {{Secret: "web/a", Field: "pin"}, {Secret: "web/b", Field: fieldPassword}},
+	}
+	fps := fingerprints(key, shaSums)
+	require.Len(t, fps, 2)
+	assert.NotEqual(t, fps["web/a"], fps["web/b"])
+	assert.Equal(t, fps, fingerprints(key, shaSums))
+	assert.NotEqual(t, fps, fingerprints([]byte("other"), shaSums))
+
+	// a changed field changes the fingerprint
+	shaSums[sha1hex("secret")][0].Field = "code"
+	assert.NotEqual(t, fps["web/a"], fingerprints(key, shaSums)["web/a"])
+	assert.Equal(t, fps["web/b"], fingerprints(key, shaSums)["web/b"])
+}
+
+func TestHIBPDumpIncremental(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx := t.Context()
+	ctx = ctxutil.WithAlwaysYes(ctx, true)
+	ctx = ctxutil.WithHidden(ctx, true)
+
+	buf := &bytes.Buffer{}
+	gp := &setCountStore{MockAPI: apimock.New()}
+	act := &hibp{
+		gp:          gp,
+		format:      formatJSON,
+		out:         buf,
+		incremental: true,
+	}
+	require.NoError(t, act.gp.Set(ctx, "web/a", &apimock.Secret{Buf: []byte("secret")}))
+	require.NoError(t, act.gp.Set(ctx, "web/b", &apimock.Secret{Buf: []byte("foobar")}))
+
+	fn := filepath.Join(dir, "dump.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSample+"\n8843D7F92416211DE9EBB963FF4CE28125932878:5\n"), 0o644))
+
+	check := func() report {
+		t.Helper()
+
+		buf.Reset()
+		require.ErrorIs(t, act.CheckDump(ctx, false, []string{fn}, 0, 0), errLeaks)
+
+		var r report
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &r))
+		// the leak is reported on every run
+		require.Len(t, r.Matches, 1)
+		assert.Equal(t, "web/b", r.Matches[0].Secret)
+		// the internal secrets are never checked
+		assert.Equal(t, 2, r.Summary.Listed)
+
+		return r
+	}
+
+	r := check()
+	assert.Equal(t, 2, r.Summary.Checked)
+	assert.Equal(t, 0, r.Summary.Unchanged)
+
+	st, err := loadState(ctx, act)
+	require.NoError(t, err)
+	srcKey, _ := sourceKey("dump", []string{fn})
+	require.Contains(t, st.Sources, srcKey)
+	assert.Contains(t, st.Sources[srcKey].Entries, "web/a")
+	assert.NotContains(t, st.Sources[srcKey].Entries, "web/b")
+
+	// the state doesn't contain any value or hash
+	sec, err := act.gp.Get(ctx, stateSecret, "latest")
+	require.NoError(t, err)
+	assert.NotContains(t, string(sec.Bytes()), "secret")
+	assert.NotContains(t, string(sec.Bytes()), sha1hex("secret"))
+
+	// unchanged secrets are skipped and the state isn't written again
+	writes := gp.sets[stateSecret]
+	r = check()
+	assert.Equal(t, 1, r.Summary.Checked)
+	assert.Equal(t, 1, r.Summary.Unchanged)
+	assert.Equal(t, writes, gp.sets[stateSecret])
+
+	// changed secrets are checked again
+	require.NoError(t, act.gp.Set(ctx, "web/a", &apimock.Secret{Buf: []byte("other")}))
+	r = check()
+	assert.Equal(t, 0, r.Summary.Unchanged)
+	r = check()
+	assert.Equal(t, 1, r.Summary.Unchanged)
+
+	// other dumps don't use the state of this one, even if they are older
+	banned := filepath.Join(dir, "banned.txt")
+	require.NoError(t, os.WriteFile(banned, []byte(sha1hex("other")+"\n"), 0o644))
+	earlier := time.Now().Add(-24 * time.Hour)
+	require.NoError(t, os.Chtimes(banned, earlier, earlier))
+	buf.Reset()
+	require.ErrorIs(t, act.CheckDump(ctx, false, []string{banned}, 0, 0), errLeaks)
+	var br report
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &br))
+	assert.Equal(t, 0, br.Summary.Unchanged)
+	require.Len(t, br.Matches, 1)
+	assert.Equal(t, "web/a", br.Matches[0].Secret)
+	// and the state of the first dump is kept
+	r = check()
+	assert.Equal(t, 1, r.Summary.Unchanged)
+
+	// so are all secrets after the dump changed
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(fn, later, later))
+	r = check()
+	assert.Equal(t, 0, r.Summary.Unchanged)
+
+	// the state is only used if asked for
+	act.incremental = false
+	r = check()
+	assert.Equal(t, 0, r.Summary.Unchanged)
+	assert.Equal(t, 2, r.Summary.Checked)
+}
+
+func TestHIBPAPIIncremental(t *testing.T) {
+	ctx := t.Context()
+	ctx = ctxutil.WithAlwaysYes(ctx, true)
+	ctx = ctxutil.WithHidden(ctx, true)
+
+	leaked := false
+	var lookups []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.TrimPrefix(r.URL.String(), "/range/")
+		lookups = append(lookups, prefix)
+		if leaked && prefix == "8843D" {
+			fmt.Fprintf(w, "7F92416211DE9EBB963FF4CE28125932878:5\n")
+		}
+	}))
+	defer ts.Close()
+	oldURL := hibpapi.URL
+	hibpapi.URL = ts.URL
+	defer func() {
+		hibpapi.URL = oldURL
+	}()
+
+	buf := &bytes.Buffer{}
+	act := &hibp{
+		gp:          apimock.New(),
+		format:      formatJSON,
+		out:         buf,
+		incremental: true,
+		recheck:     time.Hour,
+	}
+	require.NoError(t, act.gp.Set(ctx, "web/a", &apimock.Secret{Buf: []byte("foobar")}))
+	require.NoError(t, act.gp.Set(ctx, "web/c", &apimock.Secret{Buf: []byte("other")}))
+	require.NoError(t, act.CheckAPI(ctx, true, 0))
+	assert.Len(t, lookups, 2)
+
+	// the password leaks before the next recheck and is reused by a new
+	// secret. Only the new secret is looked up, but the reuse is reported.
+	leaked = true
+	lookups = nil
+	require.NoError(t, act.gp.Set(ctx, "web/b", &apimock.Secret{Buf: []byte("foobar")}))
+	buf.Reset()
+	require.ErrorIs(t, act.CheckAPI(ctx, true, 0), errLeaks)
+	assert.Equal(t, []string{"8843D"}, lookups)
+
+	var r report
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &r))
+	require.Len(t, r.Matches, 2)
+	assert.Equal(t, "web/a", r.Matches[0].Secret)
+	assert.Equal(t, "web/b", r.Matches[1].Secret)
+	assert.Equal(t, 1, r.Summary.Unchanged)
+	assert.Equal(t, 2, r.Summary.Checked)
+
+	// and the leaked secret is looked up again next time
+	st, err := loadState(ctx, act)
+	require.NoError(t, err)
+	srcKey, _ := sourceKey("api", nil)
+	assert.NotContains(t, st.Sources[srcKey].Entries, "web/a")
+	assert.Contains(t, st.Sources[srcKey].Entries, "web/c")
+}
+
+// setCountStore counts how often each secret is written.
+type setCountStore struct {
+	*apimock.MockAPI
+
+	sets map[string]int
+}
+
+func (c *setCountStore) Set(ctx context.Context, name string, sec gopass.Byter) error {
+	if c.sets == nil {
+		c.sets = make(map[string]int, 1)
+	}
+	c.sets[name]++
+
+	return c.MockAPI.Set(ctx, name, sec)
+}
@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	hapi "github.com/gopasspw/gopass-hibp/pkg/hibp/api"
 	hibpdump "github.com/gopasspw/gopass-hibp/pkg/hibp/dump"
+	"github.com/gopasspw/gopass/pkg/appdir"
 	"github.com/gopasspw/gopass/pkg/gopass/api"
 	"github.com/urfave/cli/v3"
 )
@@ -17,6 +23,17 @@ const (
 	name = "gopass-hibp"
 )
 
+// Exit codes.
+const (
+	// exitLeaks is used if leaked passwords were found.
+	exitLeaks = 1
+	// exitIncomplete is used if some secrets could not be checked or the
+	// check failed otherwise.
+	exitIncomplete = 2
+	// exitSetup is used for usage and setup errors.
+	exitSetup = 3
+)
+
 // Version is the released version of gopass.
 var version string
 
@@ -42,7 +59,7 @@ func main() {
 	gp, err := api.New(ctx)
 	if err != nil {
 		fmt.Printf("Failed to initialize gopass API: %s\n", err)
-		os.Exit(1)
+		os.Exit(exitSetup)
 	}
 
 	hibp := &hibp{
@@ -54,15 +71,35 @@ func main() {
 		Version:               getVersion().String(),
 		Usage:                 "haveibeenpwned.com leak checker for gopass",
 		EnableShellCompletion: true,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "dump-dir",
+				Usage:   "Managed dump directory used by download, dump and dumps",
+				Value:   hibpdump.DefaultDir(),
+				Sources: cli.EnvVars("GOPASS_HIBP_DUMP_DIR"),
+			},
+			&cli.StringFlag{
+				Name:    "ignore-file",
+				Usage:   "Local list of acknowledged leaks, used in addition to the one in the store",
+				Value:   filepath.Join(appdir.New(name).UserConfig(), "ignore"),
+				Sources: cli.EnvVars("GOPASS_HIBP_IGNORE_FILE"),
+			},
+		},
 		Commands: []*cli.Command{
 			{
-				Name:  "api",
-				Usage: "Detect leaked passwords using the HIBPv2 API",
+				Name:      "api",
+				Usage:     "Detect leaked passwords using the HIBPv2 API",
+				ArgsUsage: "[path ...]",
 				Description: "" +
 					"This command will decrypt all secrets and check the passwords against the public " +
-					"havibeenpwned.com v2 API.",
+					"havibeenpwned.com v2 API. " +
+					"Pass one or more paths (e.g. a mount or folder) or use '--include' and '--exclude' to only check some secrets.",
 				Action: func(ctx context.Context, cmd *cli.Command) error {
-					return hibp.CheckAPI(ctx, cmd.Bool("force"))
+					if err := hibp.configure(cmd); err != nil {
+						return err
+					}
+
+					return hibp.CheckAPI(ctx, cmd.Bool("force"), cmd.Uint64("min-count"))
 				},
 				Flags: []cli.Flag{
 					&cli.BoolFlag{
@@ -70,20 +107,78 @@ func main() {
 						Aliases: []string{"f"},
 						Usage:   "Force checking secrets against the public API",
 					},
+					&cli.StringSliceFlag{
+						Name:    "field",
+						Usage:   "Also check these fields. Key names, globs (e.g. '*_password') or Go templates (e.g. '{{ .Body }}')",
+						Sources: cli.EnvVars("GOPASS_HIBP_FIELDS"),
+					},
+					&cli.BoolFlag{
+						Name:  "history",
+						Usage: "Also check older revisions of every secret. Needs a store that can list revisions, which the gopass API can't yet",
+					},
+					&cli.StringSliceFlag{
+						Name:  "include",
+						Usage: "Only check secrets matching these globs (e.g. 'team/*')",
+					},
+					&cli.StringSliceFlag{
+						Name:  "exclude",
+						Usage: "Skip secrets matching these globs (e.g. '*/shared')",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Report format: " + strings.Join(formats, ", "),
+						Value: formatText,
+					},
+					&cli.BoolFlag{
+						Name:  "full-hash",
+						Usage: "Include the full SHA-1 hashes in the report instead of their first five characters",
+					},
+					&cli.BoolFlag{
+						Name:  "incremental",
+						Usage: "Don't look up secrets that didn't change since they were last checked without a match. They are still decrypted",
+					},
+					&cli.DurationFlag{
+						Name:  "recheck-after",
+						Usage: "Check unchanged secrets against the API again after this long when using '--incremental'",
+						Value: 7 * 24 * time.Hour,
+					},
+					&cli.Uint64Flag{
+						Name:  "min-count",
+						Usage: "Only fail on matches seen at least this many times, report others as a warning",
+					},
 				},
 			},
 			{
-				Name:  "dump",
-				Usage: "Detect leaked passwords using the HIBP SHA-1 dumps",
+				Name:      "dump",
+				Usage:     "Detect leaked passwords using the HIBP SHA-1 dumps",
+				ArgsUsage: "[path ...]",
 				Description: "" +
 					"This command will decrypt all secrets and check the passwords against the " +
 					"havibeenpwned.com SHA-1 dumps (ordered by hash). " +
 					"To use the dumps you need to download the dumps from https://haveibeenpwned.com/passwords first. Be sure to grab the one that says '(ordered by hash)'. " +
 					"This is a very expensive operation, for advanced users. " +
 					"Most users should probably use the API. " +
-					"If you want to use the dumps you need to use 7z to extract the dump: 7z x pwned-passwords-ordered-2.0.txt.7z.",
+					"If you want to use the dumps you need to use 7z to extract the dump: 7z x pwned-passwords-ordered-2.0.txt.7z. " +
+					"Alternatively use '--filter' with a filter created by 'filter build' to check against a much smaller " +
+					"probabilistic filter. " +
+					"Pass one or more paths (e.g. a mount or folder) or use '--include' and '--exclude' to only check some secrets.",
 				Action: func(ctx context.Context, cmd *cli.Command) error {
-					return hibp.CheckDump(ctx, cmd.Bool("force"), cmd.StringSlice("files"))
+					if err := hibp.configure(cmd); err != nil {
+						return err
+					}
+
+					if filter := cmd.String("filter"); filter != "" {
+						return hibp.CheckFilter(ctx, cmd.Bool("force"), filter, cmd.Bool("confirm"), cmd.Uint64("min-count"))
+					}
+
+					ctx = hibpdump.WithStrict(ctx, cmd.Bool("strict"))
+
+					dumps, err := findDumps(hibp.logWriter(), cmd.StringSlice("files"), cmd.String("dump-dir"))
+					if err != nil {
+						return err
+					}
+
+					return hibp.CheckDump(ctx, cmd.Bool("force"), dumps, cmd.Uint64("min-count"), cmd.Duration("max-age"))
 				},
 				Flags: []cli.Flag{
 					&cli.BoolFlag{
@@ -91,9 +186,61 @@ func main() {
 						Aliases: []string{"f"},
 						Usage:   "Force checking secrets against the dumps",
 					},
+					&cli.StringSliceFlag{
+						Name:    "field",
+						Usage:   "Also check these fields. Key names, globs (e.g. '*_password') or Go templates (e.g. '{{ .Body }}')",
+						Sources: cli.EnvVars("GOPASS_HIBP_FIELDS"),
+					},
+					&cli.BoolFlag{
+						Name:  "history",
+						Usage: "Also check older revisions of every secret. Needs a store that can list revisions, which the gopass API can't yet",
+					},
+					&cli.StringSliceFlag{
+						Name:  "include",
+						Usage: "Only check secrets matching these globs (e.g. 'team/*')",
+					},
+					&cli.StringSliceFlag{
+						Name:  "exclude",
+						Usage: "Skip secrets matching these globs (e.g. '*/shared')",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Report format: " + strings.Join(formats, ", "),
+						Value: formatText,
+					},
+					&cli.BoolFlag{
+						Name:  "full-hash",
+						Usage: "Include the full SHA-1 hashes in the report instead of their first five characters",
+					},
 					&cli.StringSliceFlag{
 						Name:  "files",
-						Usage: "One or more HIBP v1/v2 dumps",
+						Usage: "One or more HIBP v1/v2 dumps. Defaults to the newest dump in the dump directory",
+					},
+					&cli.StringFlag{
+						Name:  "filter",
+						Usage: "Probabilistic filter created by 'filter build' to use instead of the dumps",
+					},
+					&cli.BoolFlag{
+						Name:  "strict",
+						Usage: "Fail on the first malformed line in a dump instead of skipping it",
+					},
+					&cli.BoolFlag{
+						Name:  "confirm",
+						Usage: "Confirm probable filter matches against the public API. Only sends the prefixes of matches",
+					},
+					&cli.Uint64Flag{
+						Name:  "min-count",
+						Usage: "Only fail on matches seen at least this many times, report others as a warning",
+					},
+					&cli.BoolFlag{
+						Name:  "incremental",
+						Usage: "Don't look up secrets that didn't change since they were last checked without a match. They are still decrypted",
+					},
+					&cli.DurationFlag{
+						Name:    "max-age",
+						Usage:   "Warn if a dump is older than this. Set to 0 to disable",
+						Value:   90 * 24 * time.Hour,
+						Sources: cli.EnvVars("GOPASS_HIBP_MAX_AGE"),
 					},
 				},
 			},
@@ -101,13 +248,21 @@ func main() {
 				Name:  "download",
 				Usage: "Download HIBP dumps from the v2 API",
 				Action: func(ctx context.Context, cmd *cli.Command) error {
-					return hapi.Download(ctx, cmd.String("output"), cmd.Bool("keep"))
+					output := cmd.String("output")
+					if output == "" {
+						output = cmd.String("dump-dir")
+						if err := os.MkdirAll(output, 0o755); err != nil {
+							return err
+						}
+					}
+
+					return hapi.Download(ctx, output, cmd.Bool("keep"))
 				},
 				Flags: []cli.Flag{
 					&cli.StringFlag{
 						Name:    "output",
 						Aliases: []string{"f"},
-						Usage:   "Output location",
+						Usage:   "Output location. Defaults to the dump directory",
 					},
 					&cli.BoolFlag{
 						Name:    "keep",
@@ -116,22 +271,109 @@ func main() {
 					},
 				},
 			},
+			{
+				Name:      "check",
+				Usage:     "Check single passwords, hashes or a secret",
+				ArgsUsage: "[secret]",
+				Description: "" +
+					"This command checks the password (and the selected fields) of a single secret or the passwords " +
+					"read from stdin, one per line. If stdin is a terminal the passwords are not echoed. " +
+					"Use '--hashes' to read SHA-1 hashes instead. The passwords are checked against the API unless " +
+					"dumps or a filter are given.",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					fields, err := newExtractor(cmd.StringSlice("field"))
+					if err != nil {
+						return fmt.Errorf("%w: %w", errSetup, err)
+					}
+					hibp.fields = fields
+
+					src := checkSources{
+						filter:  cmd.String("filter"),
+						confirm: cmd.Bool("confirm"),
+					}
+					if src.filter == "" && (cmd.Bool("dump") || cmd.IsSet("files")) {
+						if src.dumps, err = findDumps(hibp.logWriter(), cmd.StringSlice("files"), cmd.String("dump-dir")); err != nil {
+							return err
+						}
+					}
+
+					var values []checkValue
+					switch cmd.Args().Len() {
+					case 0:
+						values, err = readCheckInput(ctx, os.Stdin, cmd.Bool("hashes"))
+					case 1:
+						values, err = hibp.secretValues(ctx, cmd.Args().First())
+					default:
+						return fmt.Errorf("%w: need at most one secret name", errSetup)
+					}
+					if err != nil {
+						return err
+					}
+
+					return hibp.CheckValues(ctx, values, src)
+				},
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "hashes",
+						Usage: "Read SHA-1 hashes instead of passwords from stdin",
+					},
+					&cli.StringSliceFlag{
+						Name:    "field",
+						Usage:   "Also check these fields of the secret. Key names, globs (e.g. '*_password') or Go templates (e.g. '{{ .Body }}')",
+						Sources: cli.EnvVars("GOPASS_HIBP_FIELDS"),
+					},
+					&cli.BoolFlag{
+						Name:  "dump",
+						Usage: "Check against the newest dump in the dump directory instead of the API",
+					},
+					&cli.StringSliceFlag{
+						Name:  "files",
+						Usage: "Check against these HIBP v1/v2 dumps instead of the API",
+					},
+					&cli.StringFlag{
+						Name:  "filter",
+						Usage: "Check against a filter created by 'filter build' instead of the API",
+					},
+					&cli.BoolFlag{
+						Name:  "confirm",
+						Usage: "Confirm filter hits against the API. Only the prefixes of the hits are sent",
+					},
+				},
+			},
 			{
 				Name:  "merge",
 				Usage: "Merge different dumps",
+				Description: "" +
+					"This command will merge any number of dumps ordered by hash into a single gzip compressed dump. " +
+					"The output is ordered by hash and contains every hash only once. " +
+					"Use '--strategy' to choose the count of hashes contained in more than one dump: " +
+					"max (highest count), sum (add up all counts), left (first dump), right (last dump) or " +
+					"newest (most recently modified dump). The summary compares the output to the first dump.",
 				Action: func(ctx context.Context, cmd *cli.Command) error {
 					scanner, err := hibpdump.New(cmd.StringSlice("files")...)
 					if err != nil {
-						return err
+						return fmt.Errorf("%w: %w", errSetup, err)
 					}
 
-					return scanner.Merge(ctx, cmd.String("output"))
+					strategy, err := hibpdump.ParseMergeStrategy(cmd.String("strategy"))
+					if err != nil {
+						return fmt.Errorf("%w: %w", errSetup, err)
+					}
+
+					_, err = scanner.Merge(ctx, cmd.String("output"), strategy)
+
+					return err
 				},
 				Flags: []cli.Flag{
 					&cli.StringSliceFlag{
 						Name:  "files",
 						Usage: "One or more HIBP v1/v2 dumps",
 					},
+					&cli.StringFlag{
+						Name:  "strategy",
+						Usage: "How to combine the counts of hashes contained in more than one dump (max, sum, left, right, newest)",
+						Value: string(hibpdump.MergeMax),
+					},
 					&cli.StringFlag{
 						Name:    "output",
 						Aliases: []string{"f"},
@@ -139,6 +381,295 @@ func main() {
 					},
 				},
 			},
+			{
+				Name:  "sort",
+				Usage: "Sort dumps by hash",
+				Description: "" +
+					"This command will convert one or more dumps in any order (e.g. ordered by prevalence) into a single " +
+					"gzip compressed dump ordered by hash without duplicates. " +
+					"It uses a disk-backed external merge sort, so it needs about as much free space in the temporary " +
+					"directory as the dumps take uncompressed, but never more memory than given by '--memory'.",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					scanner, err := hibpdump.New(cmd.StringSlice("files")...)
+					if err != nil {
+						return fmt.Errorf("%w: %w", errSetup, err)
+					}
+
+					return scanner.Sort(ctx, cmd.String("output"), int64(cmd.Uint64("memory"))*1024*1024, cmd.String("tmpdir"))
+				},
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "files",
+						Usage: "One or more HIBP v1/v2 dumps",
+					},
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"f"},
+						Usage:    "Output location",
+						Required: true,
+					},
+					&cli.Uint64Flag{
+						Name:  "memory",
+						Usage: "Memory to use for sorting in MB",
+						Value: hibpdump.DefaultSortMemory / 1024 / 1024,
+					},
+					&cli.StringFlag{
+						Name:  "tmpdir",
+						Usage: "Directory for temporary files. Defaults to the system temp dir",
+					},
+				},
+			},
+			{
+				Name:  "build-dump",
+				Usage: "Build a dump from a plaintext wordlist",
+				Description: "" +
+					"This command will hash every line of a plaintext wordlist, e.g. a list of banned passwords, " +
+					"and write the hashes into a gzip compressed dump ordered by hash without duplicates. " +
+					"Use it with 'dump --files' next to the HIBP dumps.",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return buildDump(ctx, cmd.String("input"), cmd.String("output"))
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "input",
+						Aliases:  []string{"i"},
+						Usage:    "Plaintext wordlist with one password per line",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"f"},
+						Usage:    "Output location",
+						Required: true,
+					},
+				},
+			},
+			{
+				Name:      "diff",
+				Usage:     "Compare two dumps",
+				ArgsUsage: "<old dump> <new dump>",
+				Description: "" +
+					"This command will compare two dumps ordered by hash and write all hashes that were added " +
+					"or whose count rose into a gzip compressed dump. The output can be used with the dump command " +
+					"to only check hashes that changed since the last refresh.",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if cmd.Args().Len() != 2 {
+						return fmt.Errorf("%w: need exactly two dumps: <old dump> <new dump>", errSetup)
+					}
+					_, err := hibpdump.Diff(ctx, cmd.Args().Get(0), cmd.Args().Get(1), cmd.String("output"))
+
+					return err
+				},
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "output",
+						Aliases:  []string{"f"},
+						Usage:    "Output location",
+						Required: true,
+					},
+				},
+			},
+			{
+				Name:      "verify",
+				Usage:     "Verify the integrity and order of dumps",
+				ArgsUsage: "<dump> [<dump> ...]",
+				Description: "" +
+					"This command will read the whole dump and report its format, hash mode, number of entries, " +
+					"malformed lines, duplicates and whether it is strictly ordered by hash. " +
+					"It exits with a non-zero status if any problems are found.",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return verifyDumps(ctx, cmd.Args().Slice())
+				},
+			},
+			{
+				Name:      "info",
+				Usage:     "Show statistics about dumps",
+				ArgsUsage: "<dump> [<dump> ...]",
+				Description: "" +
+					"This command will read the whole dump and report the number of entries, the total prevalence, " +
+					"a histogram of the counts by order of magnitude, the distribution of the hash prefixes " +
+					"and any metadata found in the header of the dump.",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					return showDumpInfo(ctx, cmd.Args().Slice())
+				},
+			},
+			{
+				Name:  "index",
+				Usage: "Build random-access checkpoints for gzip compressed dumps",
+				Description: "" +
+					"This command will create an index of decompression checkpoints next to each gzip compressed dump. " +
+					"The dump command uses it to only decompress the parts of the dump that may contain your hashes. " +
+					"Only dumps ordered by hash can be indexed. The index must be rebuilt if the dump changes.",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					scanner, err := hibpdump.New(cmd.StringSlice("files")...)
+					if err != nil {
+						return fmt.Errorf("%w: %w", errSetup, err)
+					}
+
+					err = scanner.BuildIndex(ctx, int64(cmd.Uint64("span"))*1024*1024)
+					if errors.Is(err, hibpdump.ErrNoDumps) {
+						return fmt.Errorf("%w: %w", errSetup, err)
+					}
+
+					return err
+				},
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "files",
+						Usage: "One or more gzip compressed HIBP v1/v2 dumps",
+					},
+					&cli.Uint64Flag{
+						Name:  "span",
+						Usage: "Distance between checkpoints in MB of uncompressed data",
+						Value: hibpdump.DefaultIndexSpan / 1024 / 1024,
+					},
+				},
+			},
+			{
+				Name:      "filter",
+				Usage:     "Remove rare hashes from dumps and manage probabilistic filters",
+				ArgsUsage: "<input dump> <output dump>",
+				Description: "" +
+					"This command will copy all hashes of a dump ordered by hash seen at least '--min-count' times " +
+					"into a smaller gzip compressed dump. Use 'filter build' to create a probabilistic filter instead.",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if cmd.Args().Len() != 2 {
+						return fmt.Errorf("%w: need exactly two arguments: <input dump> <output dump>", errSetup)
+					}
+					// not a required flag, that would apply to 'filter build' as well
+					if !cmd.IsSet("min-count") {
+						return fmt.Errorf("%w: need a minimum count (--min-count)", errSetup)
+					}
+
+					_, err := hibpdump.FilterDump(ctx, cmd.Args().Get(0), cmd.Args().Get(1), cmd.Uint64("min-count"))
+
+					return err
+				},
+				Flags: []cli.Flag{
+					&cli.Uint64Flag{
+						Name:  "min-count",
+						Usage: "Only keep hashes seen at least this many times",
+					},
+				},
+				Commands: []*cli.Command{
+					{
+						Name:  "build",
+						Usage: "Compile dumps into a compact probabilistic filter",
+						Description: "" +
+							"This command will compile one or more HIBP dumps into a Bloom filter. " +
+							"The filter is much smaller than the dumps and can be used with 'dump --filter'. " +
+							"Matches found in the filter are only probable and may be confirmed against the API.",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							scanner, err := hibpdump.New(cmd.StringSlice("files")...)
+							if err != nil {
+								return fmt.Errorf("%w: %w", errSetup, err)
+							}
+
+							return scanner.BuildFilter(ctx, cmd.String("output"), cmd.Float64("fp-rate"), cmd.Uint64("min-count"))
+						},
+						Flags: []cli.Flag{
+							&cli.StringSliceFlag{
+								Name:  "files",
+								Usage: "One or more HIBP v1/v2 dumps",
+							},
+							&cli.StringFlag{
+								Name:     "output",
+								Aliases:  []string{"f"},
+								Usage:    "Output location",
+								Required: true,
+							},
+							&cli.Float64Flag{
+								Name:  "fp-rate",
+								Usage: "False positive rate of the filter",
+								Value: hibpdump.DefaultFalsePositiveRate,
+							},
+							&cli.Uint64Flag{
+								Name:  "min-count",
+								Usage: "Only add hashes seen at least this many times",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:  "dumps",
+				Usage: "Manage the dump directory",
+				Commands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "List all dumps in the dump directory",
+						Action: func(_ context.Context, cmd *cli.Command) error {
+							return listDumps(cmd.String("dump-dir"))
+						},
+					},
+					{
+						Name:  "prune",
+						Usage: "Remove old dumps from the dump directory",
+						Action: func(_ context.Context, cmd *cli.Command) error {
+							return pruneDumps(cmd.String("dump-dir"), int(cmd.Uint64("keep")))
+						},
+						Flags: []cli.Flag{
+							&cli.Uint64Flag{
+								Name:  "keep",
+								Usage: "Number of dumps to keep",
+								Value: 1,
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:  "ignore",
+				Usage: "Manage the list of acknowledged leaks",
+				Description: "" +
+					"Matches on the ignore list are reported as acknowledged and don't fail the check until the entry expires. " +
+					"The ignore list is read from the secret " + ignoreSecret + " and from the ignore file. " +
+					"Every line holds a secret name, a glob or a HMAC of a password hash, the expiry date (YYYY-MM-DD) and a reason.",
+				Commands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "List all acknowledged leaks",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							hibp.ignoreFile = cmd.String("ignore-file")
+
+							return hibp.listIgnored(ctx)
+						},
+					},
+					{
+						Name:      "add",
+						Usage:     "Acknowledge the leak of a secret",
+						ArgsUsage: "<secret>",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							if cmd.Args().Len() != 1 {
+								return fmt.Errorf("%w: need exactly one secret name or glob", errSetup)
+							}
+							hibp.ignoreFile = cmd.String("ignore-file")
+
+							return hibp.addIgnored(ctx, cmd.Args().First(), cmd.Bool("hash"), cmd.Bool("store"), cmd.String("expires"), cmd.String("reason"))
+						},
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "reason",
+								Usage:    "Why the leak is acceptable",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "expires",
+								Usage: "Last day (YYYY-MM-DD) the leak is acknowledged",
+								Value: time.Now().AddDate(0, 0, 90).Format(expiryLayout),
+							},
+							&cli.BoolFlag{
+								Name:  "hash",
+								Usage: "Acknowledge the password of the secret wherever it is used, stored as a HMAC of its hash",
+							},
+							&cli.BoolFlag{
+								Name:  "store",
+								Usage: "Add the entry to the ignore list in the store instead of the ignore file",
+							},
+						},
+					},
+				},
+			},
 			{
 				Name: "version",
 				Action: func(_ context.Context, cmd *cli.Command) error {
@@ -150,7 +681,67 @@ func main() {
 		},
 	}
 
+	markUsageErrors(app)
+
 	if err := app.Run(ctx, os.Args); err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		os.Exit(exitCode(err))
 	}
 }
+
+// markUsageErrors marks the usage errors of cmd and all of its subcommands,
+// e.g. unknown flags, as setup errors.
+func markUsageErrors(cmd *cli.Command) {
+	cmd.OnUsageError = func(_ context.Context, cmd *cli.Command, err error, _ bool) error {
+		_ = cli.ShowSubcommandHelp(cmd)
+
+		return fmt.Errorf("%w: %w", errSetup, err)
+	}
+	for _, sub := range cmd.Commands {
+		markUsageErrors(sub)
+	}
+}
+
+// exitCode maps an error to the exit code of the process. Any other error
+// than a usage or setup error means the check failed before it was complete.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errLeaks):
+		return exitLeaks
+	case errors.Is(err, errSetup):
+		return exitSetup
+	default:
+		return exitIncomplete
+	}
+}
+
+// configure applies the options shared by the api and dump commands.
+func (s *hibp) configure(cmd *cli.Command) error {
+	fields, err := newExtractor(cmd.StringSlice("field"))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errSetup, err)
+	}
+	s.fields = fields
+	s.history = cmd.Bool("history")
+	s.ignoreFile = cmd.String("ignore-file")
+	s.fullHash = cmd.Bool("full-hash")
+	s.incremental = cmd.Bool("incremental")
+	s.recheck = cmd.Duration("recheck-after")
+
+	s.format = cmd.String("format")
+	if !slices.Contains(formats, s.format) {
+		return fmt.Errorf("%w: unknown format %q, use one of %s", errSetup, s.format, strings.Join(formats, ", "))
+	}
+	if s.format != formatText {
+		// keep stdout machine readable, progress and warnings go to stderr
+		s.log = os.Stderr
+	}
+
+	sel, err := newSelector(cmd.Args().Slice(), cmd.StringSlice("include"), cmd.StringSlice("exclude"))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errSetup, err)
+	}
+	s.selector = sel
+
+	return nil
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,6 +17,7 @@ import (
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	hibpdump "github.com/gopasspw/gopass-hibp/pkg/hibp/dump"
 	"github.com/gopasspw/gopass/pkg/ctxutil"
 	"github.com/gopasspw/gopass/pkg/debug"
 	"github.com/gopasspw/gopass/pkg/fsutil"
@@ -41,6 +45,11 @@ func Download(ctx context.Context, path string, keep bool) error {
 
 	fmt.Printf("Downloading hashes to %s ...", dir)
 
+	meta := &hibpdump.Metadata{
+		Source:  URL + "/range/",
+		Started: time.Now(),
+	}
+
 	maxVal := 1024 * 1024
 	bar := termio.NewProgressBar(int64(maxVal))
 	bar.Hidden = ctxutil.IsHidden(ctx)
@@ -63,34 +72,47 @@ func Download(ctx context.Context, path string, keep bool) error {
 	}
 	wg.Wait()
 	bar.Done()
+	meta.Finished = time.Now()
 
 	fmt.Println("Download done.")
 
 	fmt.Println("Assembling chunks ...")
 
-	if err := joinChunks(dir, path, keep); err != nil {
+	if err := joinChunks(dir, path, keep, meta); err != nil {
 		return err
 	}
 
 	fmt.Printf("Chunks assembled at %s\n", path)
+	if meta.Prefixes < hibpdump.TotalPrefixes {
+		fmt.Printf("Warning: Only %d of %d prefixes were downloaded. Use --keep to resume.\n", meta.Prefixes, hibpdump.TotalPrefixes)
+	}
 
 	return nil
 }
 
-func joinChunks(dir, path string, keep bool) error {
+// joinChunks concatenates all chunks into a single dump at path and writes its
+// metadata sidecar.
+func joinChunks(dir, path string, keep bool, meta *hibpdump.Metadata) error {
 	dirs, err := os.ReadDir(dir)
 	if err != nil {
 		return err
 	}
 
-	fh, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	// write to a temporary file first so an interrupted run never leaves a
+	// truncated dump behind
+	fh, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
 	if err != nil {
 		return err
 	}
-	defer fh.Close() //nolint:errcheck
+	defer func() {
+		_ = fh.Close()
+		_ = os.Remove(path + ".tmp")
+	}()
 
-	gzw := gzip.NewWriter(fh)
+	checksum := sha256.New()
+	gzw := gzip.NewWriter(io.MultiWriter(fh, checksum))
 	defer gzw.Close() //nolint:errcheck
+	lc := &lineCounter{w: gzw}
 
 	bar := termio.NewProgressBar(int64(len(dirs)))
 
@@ -105,14 +127,35 @@ func joinChunks(dir, path string, keep bool) error {
 		if !strings.HasSuffix(de.Name(), ".gz") {
 			continue
 		}
-		if err := copyChunk(gzw, filepath.Join(dir, de.Name())); err != nil {
+		n, err := copyChunk(lc, filepath.Join(dir, de.Name()))
+		if err != nil {
 			return err
 		}
+		if n > 0 {
+			meta.Prefixes++
+		}
 
 		bar.Inc()
 	}
 	bar.Done()
 
+	if err := gzw.Close(); err != nil {
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	meta.HashMode = hibpdump.HashModeSHA1
+	meta.Entries = lc.lines
+	meta.SHA256 = hex.EncodeToString(checksum.Sum(nil))
+	if err := hibpdump.WriteMetadata(path, meta); err != nil {
+		return err
+	}
+
 	if keep {
 		return nil
 	}
@@ -120,23 +163,35 @@ func joinChunks(dir, path string, keep bool) error {
 	return os.RemoveAll(dir)
 }
 
-func copyChunk(w io.Writer, fn string) error {
+func copyChunk(w io.Writer, fn string) (int64, error) {
 	fh, err := os.Open(fn)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer fh.Close() //nolint:errcheck
 
 	gzr, err := gzip.NewReader(fh)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer gzr.Close() //nolint:errcheck
 
 	n, err := io.Copy(w, gzr)
 	debug.Log("Copied %d bytes from %s", n, fn)
 
-	return err
+	return n, err
+}
+
+// lineCounter counts the lines written through it.
+type lineCounter struct {
+	w     io.Writer
+	lines uint64
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	c.lines += uint64(bytes.Count(p, []byte{'\n'}))
+
+	return c.w.Write(p)
 }
 
 func downloadChunk(chunk int, dir string, keep bool) error {
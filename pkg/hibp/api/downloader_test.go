@@ -0,0 +1,52 @@
+package api
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	hibpdump "github.com/gopasspw/gopass-hibp/pkg/hibp/dump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinChunks(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	dir := filepath.Join(td, ".hibp-dl")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+
+	for prefix, content := range map[string]string{
+		"00000": "00000005AD76BD555C1D6D771DE417A4B87E4B4:1\n00000000A8DAE4228F821FB418F59826079BF368:2\n",
+		"00001": "",
+		"00002": "0000010F4B38525354491E099EB1796278544B1:3\n",
+	} {
+		fh, err := os.Create(filepath.Join(dir, prefix+".gz"))
+		require.NoError(t, err)
+		gzw := gzip.NewWriter(fh)
+		_, err = gzw.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, gzw.Close())
+		require.NoError(t, fh.Close())
+	}
+
+	out := filepath.Join(td, "dump.txt.gz")
+	meta := &hibpdump.Metadata{Source: "test"}
+	require.NoError(t, joinChunks(dir, out, false, meta))
+	assert.NoDirExists(t, dir)
+	assert.NoFileExists(t, out+".tmp")
+
+	meta, err := hibpdump.ReadMetadata(out)
+	require.NoError(t, err)
+	assert.Equal(t, "test", meta.Source)
+	assert.Equal(t, hibpdump.HashModeSHA1, meta.HashMode)
+	assert.Equal(t, uint64(3), meta.Entries)
+	assert.Equal(t, uint64(2), meta.Prefixes)
+
+	r, err := hibpdump.Verify(t.Context(), out)
+	require.NoError(t, err)
+	assert.True(t, r.HasChecksum)
+	assert.True(t, r.ChecksumOK)
+}
@@ -0,0 +1,213 @@
+package dump
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/termio"
+)
+
+// DiffReport summarizes the changes between two dumps.
+type DiffReport struct {
+	// Added is the number of hashes only contained in the new dump.
+	Added uint64
+	// Increased is the number of hashes whose count rose.
+	Increased uint64
+	// Decreased is the number of hashes whose count dropped.
+	Decreased uint64
+	// Unchanged is the number of hashes with the same (or an unknown) count.
+	Unchanged uint64
+	// Removed is the number of hashes only contained in the old dump.
+	Removed uint64
+	// Duplicates is the number of repeated hashes in either dump. They are
+	// folded into one entry with the highest count.
+	Duplicates uint64
+}
+
+// String returns a short human readable summary.
+func (r DiffReport) String() string {
+	return fmt.Sprintf("%d added, %d with increased counts, %d with decreased counts, %d unchanged, %d removed",
+		r.Added, r.Increased, r.Decreased, r.Unchanged, r.Removed)
+}
+
+// diffSource is a merge source that folds consecutive duplicate hashes into
+// one entry with the highest count. Merged dumps often contain these.
+type diffSource struct {
+	src *mergeSource
+	// pending is set if src holds an entry not returned yet.
+	pending    bool
+	sum        hashSum
+	count      uint64
+	duplicates uint64
+}
+
+func newDiffSource(ctx context.Context, fn string, idx int) (*diffSource, error) {
+	src, err := newMergeSource(ctx, fn, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &diffSource{src: src}
+	if d.pending, err = src.next(); err != nil {
+		src.close()
+
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// next advances to the next distinct hash. It returns false if the input is
+// exhausted.
+func (d *diffSource) next() (bool, error) {
+	if !d.pending {
+		return false, nil
+	}
+
+	d.sum, d.count = d.src.sum, d.src.count
+	for {
+		ok, err := d.src.next()
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			d.pending = false
+
+			return true, nil
+		}
+		if d.src.sum != d.sum {
+			return true, nil
+		}
+		d.count = max(d.count, d.src.count)
+		d.duplicates++
+	}
+}
+
+func (d *diffSource) close() {
+	d.src.close()
+}
+
+// Diff walks two dumps ordered by hash and writes all hashes that were added
+// in newDump or whose count rose since oldDump into a gzip compressed dump,
+// using the counts of newDump. Counts can only rise if they are known in both
+// dumps.
+func Diff(ctx context.Context, oldDump, newDump, outfile string) (*DiffReport, error) {
+	for _, dump := range []string{oldDump, newDump} {
+		if !isSorted(dump) {
+			return nil, fmt.Errorf("%s is not ordered by hash", dump)
+		}
+	}
+	if !strings.HasSuffix(outfile, ".gz") {
+		outfile += ".gz"
+	}
+
+	fmt.Printf("Comparing %s to %s\n", newDump, oldDump)
+
+	oldSrc, err := newDiffSource(ctx, oldDump, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer oldSrc.close()
+
+	newSrc, err := newDiffSource(ctx, newDump, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer newSrc.close()
+
+	oldOK, err := oldSrc.next()
+	if err != nil {
+		return nil, err
+	}
+	newOK, err := newSrc.next()
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := newDumpWriter(outfile, gzip.DefaultCompression, &Metadata{
+		Source: "diff",
+		Inputs: []string{oldDump, newDump},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer w.abort()
+
+	bar := termio.NewProgressBar(1 << 16)
+	bar.Hidden = ctxutil.IsHidden(ctx)
+
+	report := &DiffReport{}
+	for oldOK || newOK {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("user aborted")
+		default:
+		}
+
+		var c int
+		switch {
+		case !oldOK:
+			c = 1
+		case !newOK:
+			c = -1
+		default:
+			c = oldSrc.sum.compare(&newSrc.sum)
+		}
+
+		if c < 0 {
+			report.Removed++
+			if oldOK, err = oldSrc.next(); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if c > 0 {
+			report.Added++
+			if err := w.write(newSrc.sum, newSrc.count); err != nil {
+				return nil, err
+			}
+		} else {
+			switch {
+			case oldSrc.count > 0 && newSrc.count > oldSrc.count:
+				report.Increased++
+				if err := w.write(newSrc.sum, newSrc.count); err != nil {
+					return nil, err
+				}
+			case newSrc.count > 0 && newSrc.count < oldSrc.count:
+				report.Decreased++
+			default:
+				report.Unchanged++
+			}
+			if oldOK, err = oldSrc.next(); err != nil {
+				return nil, err
+			}
+		}
+
+		bar.Set(int64(newSrc.sum[0])<<8 | int64(newSrc.sum[1]))
+		if newOK, err = newSrc.next(); err != nil {
+			return nil, err
+		}
+	}
+	bar.Done()
+
+	for _, src := range []*diffSource{oldSrc, newSrc} {
+		if src.duplicates > 0 {
+			fmt.Printf("Warning: %s contains %d duplicate hashes, using the highest count\n", src.src.fn, src.duplicates)
+		}
+		report.Duplicates += src.duplicates
+	}
+
+	if err := w.commit(); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("%s\n", report)
+	fmt.Printf("Wrote %d hashes to %s\n", w.entries, outfile)
+
+	return report, nil
+}
@@ -0,0 +1,83 @@
+package dump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := ctxutil.WithHidden(t.Context(), true)
+
+	oldDump := filepath.Join(td, "old.txt")
+	require.NoError(t, os.WriteFile(oldDump, []byte(""+
+		"0000000000000000000000000000000000000001:9\n"+
+		"0000000000000000000000000000000000000003:10\n"+
+		"0000000000000000000000000000000000000005:5\n"+
+		"0000000000000000000000000000000000000006\n"+
+		"0000000000000000000000000000000000000007:7\n"), 0o644))
+	newDump := filepath.Join(td, "new.txt.gz")
+	require.NoError(t, testWriteGZ(newDump, []byte(""+
+		"0000000000000000000000000000000000000001:9\n"+
+		"0000000000000000000000000000000000000002:2\n"+
+		"0000000000000000000000000000000000000003:12\n"+
+		"0000000000000000000000000000000000000005:4\n"+
+		"0000000000000000000000000000000000000006:6\n"+
+		"0000000000000000000000000000000000000008:8\n")))
+
+	out := filepath.Join(td, "diff")
+	report, err := Diff(ctx, oldDump, newDump, out)
+	require.NoError(t, err)
+	assert.Equal(t, DiffReport{
+		Added:     2,
+		Increased: 1,
+		Decreased: 1,
+		Unchanged: 2,
+		Removed:   1,
+	}, *report)
+	assert.Equal(t, "0000000000000000000000000000000000000002:2\n"+
+		"0000000000000000000000000000000000000003:12\n"+
+		"0000000000000000000000000000000000000008:8\n", testReadGZ(t, out+".gz"))
+
+	// the diff is a valid dump
+	scanner, err := New(out + ".gz")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0000000000000000000000000000000000000003"},
+		scanner.LookupBatch(ctx, []string{"0000000000000000000000000000000000000003", "0000000000000000000000000000000000000001"}))
+
+	// duplicates are folded, keeping the highest count
+	dupDump := filepath.Join(td, "dup.txt")
+	require.NoError(t, os.WriteFile(dupDump, []byte(""+
+		"0000000000000000000000000000000000000001:9\n"+
+		"0000000000000000000000000000000000000002:2\n"+
+		"0000000000000000000000000000000000000002:3\n"+
+		"0000000000000000000000000000000000000002\n"+
+		"0000000000000000000000000000000000000003:10\n"+
+		"0000000000000000000000000000000000000003:11\n"), 0o644))
+	report, err = Diff(ctx, oldDump, dupDump, out)
+	require.NoError(t, err)
+	assert.Equal(t, DiffReport{
+		Added:      1,
+		Increased:  1,
+		Unchanged:  1,
+		Removed:    3,
+		Duplicates: 3,
+	}, *report)
+	assert.Equal(t, "0000000000000000000000000000000000000002:3\n"+
+		"0000000000000000000000000000000000000003:11\n", testReadGZ(t, out+".gz"))
+
+	// unsorted inputs are rejected
+	unsorted := filepath.Join(td, "unsorted.txt")
+	require.NoError(t, os.WriteFile(unsorted, []byte(""+
+		"0000000000000000000000000000000000000002:2\n"+
+		"0000000000000000000000000000000000000001:1\n"), 0o644))
+	_, err = Diff(ctx, unsorted, newDump, out)
+	require.Error(t, err)
+}
@@ -0,0 +1,266 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/debug"
+	"github.com/gopasspw/gopass/pkg/termio"
+)
+
+// filterMagic identifies a gopass-hibp Bloom filter file (format version 1).
+var filterMagic = []byte("GPHIBPB1")
+
+// filterHeaderSize is the size of the fixed header preceding the bitset:
+// magic, k, m, n, minCount and the false positive rate.
+const filterHeaderSize = 8 + 5*8
+
+// DefaultFalsePositiveRate is the default false positive rate used when
+// building a filter. At 0.1% the filter needs about 14.4 bits per entry.
+const DefaultFalsePositiveRate = 0.001
+
+// Filter is a probabilistic (Bloom) filter compiled from one or more HIBP
+// dumps. A lookup never yields false negatives but may report hashes as
+// present that were not in the dumps. Those matches are only "probable"
+// and can be confirmed against the API.
+//
+// The filter is not loaded into memory. Each lookup reads the required
+// bits directly from the file.
+type Filter struct {
+	fh       *os.File
+	k        uint64
+	m        uint64
+	n        uint64
+	minCount uint64
+	fpRate   float64
+}
+
+// BuildFilter compiles all dumps of this scanner into a Bloom filter with
+// the given false positive rate. If minCount is greater than zero only hashes
+// with at least that prevalence are added. Entries without a count (v1 dumps)
+// are always added.
+func (s *Scanner) BuildFilter(ctx context.Context, outfile string, fpRate float64, minCount uint64) error {
+	if fpRate <= 0 || fpRate >= 1 {
+		return fmt.Errorf("false positive rate must be between 0 and 1")
+	}
+
+	fmt.Printf("Counting entries in %+v ...\n", s.dumps)
+	var n uint64
+	for _, fn := range s.dumps {
+		if err := s.eachEntry(ctx, fn, minCount, func([]byte) { n++ }); err != nil {
+			return err
+		}
+	}
+	if n < 1 {
+		return fmt.Errorf("no entries to add")
+	}
+
+	m, k := filterParams(n, fpRate)
+	fmt.Printf("Building filter with %d entries, %d hash functions and %d MiB ...\n", n, k, m/8/1024/1024)
+
+	bits := make([]byte, m/8)
+	bar := termio.NewProgressBar(int64(n))
+	bar.Hidden = ctxutil.IsHidden(ctx)
+	for _, fn := range s.dumps {
+		if err := s.eachEntry(ctx, fn, minCount, func(sum []byte) {
+			h1, h2 := filterHashes(sum)
+			for i := range k {
+				pos := (h1 + i*h2) % m
+				bits[pos/8] |= 1 << (pos % 8)
+			}
+			bar.Inc()
+		}); err != nil {
+			return err
+		}
+	}
+	bar.Done()
+
+	fh, err := os.OpenFile(outfile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close() //nolint:errcheck
+
+	bw := bufio.NewWriter(fh)
+	hdr := make([]byte, filterHeaderSize)
+	copy(hdr, filterMagic)
+	binary.BigEndian.PutUint64(hdr[8:], k)
+	binary.BigEndian.PutUint64(hdr[16:], m)
+	binary.BigEndian.PutUint64(hdr[24:], n)
+	binary.BigEndian.PutUint64(hdr[32:], minCount)
+	binary.BigEndian.PutUint64(hdr[40:], math.Float64bits(fpRate))
+	if _, err := bw.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := bw.Write(bits); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Filter written to %s\n", outfile)
+
+	return fh.Close()
+}
+
+// eachEntry calls cb with the binary SHA-1 sum of every entry in the given
+// dump with a prevalence of at least minCount.
+func (s *Scanner) eachEntry(ctx context.Context, fn string, minCount uint64, cb func([]byte)) error {
+	_, err := eachDumpEntry(ctx, fn, func(sum hashSum, count uint64) error {
+		if minCount > 0 && count > 0 && count < minCount {
+			return nil
+		}
+		cb(sum[:])
+
+		return nil
+	})
+
+	return err
+}
+
+// filterParams returns the optimal number of bits (m) and hash functions (k)
+// for n entries and the given false positive rate.
+func filterParams(n uint64, fpRate float64) (uint64, uint64) {
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	// round up to full bytes
+	m = (m + 7) / 8 * 8
+	m = max(m, 64)
+
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	k = max(k, 1)
+
+	return m, k
+}
+
+// filterHashes derives the two base hashes for double hashing. Since the input
+// already is a SHA-1 sum we can use its bytes directly.
+func filterHashes(sum []byte) (uint64, uint64) {
+	return binary.BigEndian.Uint64(sum[0:8]), binary.BigEndian.Uint64(sum[8:16]) | 1
+}
+
+// OpenFilter opens a filter previously created with BuildFilter.
+func OpenFilter(fn string) (*Filter, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := make([]byte, filterHeaderSize)
+	if _, err := io.ReadFull(fh, hdr); err != nil {
+		_ = fh.Close()
+
+		return nil, fmt.Errorf("failed to read filter header from %s: %w", fn, err)
+	}
+	if !bytes.Equal(hdr[:8], filterMagic) {
+		_ = fh.Close()
+
+		return nil, fmt.Errorf("%s is not a gopass-hibp filter", fn)
+	}
+
+	f := &Filter{
+		fh:       fh,
+		k:        binary.BigEndian.Uint64(hdr[8:]),
+		m:        binary.BigEndian.Uint64(hdr[16:]),
+		n:        binary.BigEndian.Uint64(hdr[24:]),
+		minCount: binary.BigEndian.Uint64(hdr[32:]),
+		fpRate:   math.Float64frombits(binary.BigEndian.Uint64(hdr[40:])),
+	}
+	if f.k < 1 || f.m < 8 {
+		_ = fh.Close()
+
+		return nil, fmt.Errorf("invalid filter header in %s", fn)
+	}
+	debug.Log("opened filter %s: k=%d m=%d n=%d minCount=%d fpRate=%f", fn, f.k, f.m, f.n, f.minCount, f.fpRate)
+
+	return f, nil
+}
+
+// Close closes the underlying file.
+func (f *Filter) Close() error {
+	return f.fh.Close()
+}
+
+// MinCount returns the minimum prevalence used when building the filter.
+func (f *Filter) MinCount() uint64 {
+	return f.minCount
+}
+
+// FalsePositiveRate returns the false positive rate the filter was built for.
+func (f *Filter) FalsePositiveRate() float64 {
+	return f.fpRate
+}
+
+// Lookup returns true if the SHA-1 hash is probably contained in the filter.
+func (f *Filter) Lookup(hash string) (bool, error) {
+	sum, err := hex.DecodeString(strings.TrimSpace(hash))
+	if err != nil || len(sum) != 20 {
+		return false, fmt.Errorf("invalid shasum")
+	}
+
+	h1, h2 := filterHashes(sum)
+	buf := make([]byte, 1)
+	for i := range f.k {
+		pos := (h1 + i*h2) % f.m
+		if _, err := f.fh.ReadAt(buf, filterHeaderSize+int64(pos/8)); err != nil {
+			return false, err
+		}
+		if buf[0]&(1<<(pos%8)) == 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// LookupBatch takes a slice of SHA-1 hashes and returns those that are
+// probably contained in the filter. Hashes that can't be looked up are
+// skipped, use LookupBatchErr to handle them.
+func (f *Filter) LookupBatch(ctx context.Context, in []string) []string {
+	out, errs := f.LookupBatchErr(ctx, in)
+	for hash, err := range errs {
+		debug.Log("failed to lookup %s: %s", hash, err)
+	}
+
+	return out
+}
+
+// LookupBatchErr works like LookupBatch but also returns the error of every
+// hash that could not be looked up, keyed by the upper case hash. If ctx is
+// canceled the remaining hashes fail with the error of the context.
+func (f *Filter) LookupBatchErr(ctx context.Context, in []string) ([]string, map[string]error) {
+	out := make([]string, 0, len(in))
+	errs := make(map[string]error)
+	for i, hash := range in {
+		select {
+		case <-ctx.Done():
+			for _, rest := range in[i:] {
+				errs[strings.ToUpper(rest)] = ctx.Err()
+			}
+
+			return out, errs
+		default:
+		}
+
+		found, err := f.Lookup(hash)
+		if err != nil {
+			errs[strings.ToUpper(hash)] = err
+
+			continue
+		}
+		if found {
+			out = append(out, strings.ToUpper(hash))
+		}
+	}
+
+	return out, errs
+}
@@ -0,0 +1,100 @@
+package dump
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := ctxutil.WithHidden(t.Context(), true)
+
+	fn := filepath.Join(td, "dump.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSampleSorted), 0o644))
+
+	scanner, err := New(fn)
+	require.NoError(t, err)
+
+	ffn := filepath.Join(td, "dump.bloom")
+	require.Error(t, scanner.BuildFilter(ctx, ffn, 0, 0))
+	require.NoError(t, scanner.BuildFilter(ctx, ffn, DefaultFalsePositiveRate, 0))
+
+	f, err := OpenFilter(ffn)
+	require.NoError(t, err)
+	defer f.Close() //nolint:errcheck
+
+	assert.InDelta(t, DefaultFalsePositiveRate, f.FalsePositiveRate(), 0.0000001)
+
+	found, err := f.Lookup("00000000a8dae4228f821fb418f59826079bf368")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = f.Lookup("8843D7F92416211DE9EBB963FF4CE28125932878")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, err = f.Lookup("foobar")
+	require.Error(t, err)
+
+	assert.Equal(t, []string{
+		"000000005AD76BD555C1D6D771DE417A4B87E4B4",
+		"0000000FC1C08E6454BED24F463EA2129E254D43",
+	}, f.LookupBatch(ctx, []string{
+		"000000005AD76BD555C1D6D771DE417A4B87E4B4",
+		"0000000FC1C08E6454BED24F463EA2129E254D43",
+		"8843D7F92416211DE9EBB963FF4CE28125932878",
+		"foobar",
+	}))
+
+	hits, errs := f.LookupBatchErr(ctx, []string{"000000005AD76BD555C1D6D771DE417A4B87E4B4", "foobar"})
+	assert.Equal(t, []string{"000000005AD76BD555C1D6D771DE417A4B87E4B4"}, hits)
+	require.Len(t, errs, 1)
+	require.Error(t, errs["FOOBAR"])
+
+	// unchecked hashes are no clean pass
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	hits, errs = f.LookupBatchErr(canceled, []string{"000000005ad76bd555c1d6d771de417a4b87e4b4"})
+	assert.Empty(t, hits)
+	require.ErrorIs(t, errs["000000005AD76BD555C1D6D771DE417A4B87E4B4"], context.Canceled)
+
+	// not a filter
+	_, err = OpenFilter(fn)
+	require.Error(t, err)
+}
+
+func TestFilterMinCount(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := ctxutil.WithHidden(t.Context(), true)
+
+	fn := filepath.Join(td, "dump.txt")
+	require.NoError(t, os.WriteFile(fn, []byte("00000000A8DAE4228F821FB418F59826079BF368:2\n00000000DD7F2A1C68A35673713783CA390C9E93:42\n"), 0o644))
+
+	scanner, err := New(fn)
+	require.NoError(t, err)
+
+	ffn := filepath.Join(td, "dump.bloom")
+	require.NoError(t, scanner.BuildFilter(ctx, ffn, DefaultFalsePositiveRate, 10))
+
+	f, err := OpenFilter(ffn)
+	require.NoError(t, err)
+	defer f.Close() //nolint:errcheck
+
+	assert.Equal(t, uint64(10), f.MinCount())
+	assert.Equal(t, []string{
+		"00000000DD7F2A1C68A35673713783CA390C9E93",
+	}, f.LookupBatch(ctx, []string{
+		"00000000A8DAE4228F821FB418F59826079BF368",
+		"00000000DD7F2A1C68A35673713783CA390C9E93",
+	}))
+}
@@ -0,0 +1,487 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/debug"
+	"github.com/gopasspw/gopass/pkg/termio"
+)
+
+// indexMagic identifies a gopass-hibp gzip checkpoint index (format version 2).
+var indexMagic = []byte("GPHIBPZ2")
+
+// indexCheckSize is the number of bytes at the start and at the end of a dump
+// covered by the checksum stored in the index. This includes the gzip header
+// and the CRC-32 of the last member.
+const indexCheckSize = 64 * 1024
+
+// indexMaxVisits is the share of the checkpoints an indexed scan may visit.
+// Resuming at a checkpoint uses an inflater about four times slower than
+// streaming the whole dump, so dumps are streamed if the input hashes are
+// spread over more checkpoints.
+const indexMaxVisits = 0.125
+
+// DefaultIndexSpan is the default distance between two checkpoints in
+// uncompressed bytes.
+const DefaultIndexSpan = 16 * 1024 * 1024
+
+// Index is a list of decompression checkpoints into a gzip compressed dump
+// that is ordered by hash. Each checkpoint stores the position of a DEFLATE
+// block, the 32 KiB of output preceding it and the first hash following it
+// (zran-style). This allows the scanner to start decompressing close to the
+// hashes it is looking for instead of streaming the whole file.
+type Index struct {
+	// size, mtime and sum identify the dump the index was built for.
+	size        int64
+	mtime       int64
+	sum         []byte
+	checkpoints []checkpoint
+}
+
+type checkpoint struct {
+	bitOff int64
+	out    int64
+	hash   string
+	window []byte
+	// crc and member allow verifying the gzip trailer after resuming.
+	crc    uint32
+	member int64
+}
+
+// indexChecksum returns the size, the modification time and a SHA-256 over the
+// first and last bytes of the file.
+func indexChecksum(fh *os.File) (int64, int64, []byte, error) {
+	fi, err := fh.Stat()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(fh, 0, min(fi.Size(), indexCheckSize))); err != nil {
+		return 0, 0, nil, err
+	}
+	tail := max(fi.Size()-indexCheckSize, 0)
+	if _, err := io.Copy(h, io.NewSectionReader(fh, tail, fi.Size()-tail)); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return fi.Size(), fi.ModTime().UnixNano(), h.Sum(nil), nil
+}
+
+// IndexFilename returns the location of the checkpoint index for the given
+// dump.
+func IndexFilename(fn string) string {
+	return fn + ".zidx"
+}
+
+// BuildIndex creates a checkpoint index for every gzip compressed dump of
+// this scanner. span is the minimum distance between two checkpoints in
+// uncompressed bytes. The dumps must be ordered by hash.
+func (s *Scanner) BuildIndex(ctx context.Context, span int64) error {
+	if span < 1 {
+		span = DefaultIndexSpan
+	}
+
+	var found bool
+	for _, fn := range s.dumps {
+		if !strings.HasSuffix(fn, ".gz") {
+			fmt.Printf("Skipping %s. Only gzip compressed dumps can be indexed.\n", fn)
+
+			continue
+		}
+		found = true
+
+		fmt.Printf("Indexing %s ...\n", fn)
+		idx, err := buildIndex(ctx, fn, span)
+		if err != nil {
+			return fmt.Errorf("failed to index %s: %w", fn, err)
+		}
+		if err := idx.write(IndexFilename(fn)); err != nil {
+			return fmt.Errorf("failed to write index for %s: %w", fn, err)
+		}
+		fmt.Printf("Wrote %d checkpoints to %s\n", len(idx.checkpoints), IndexFilename(fn))
+	}
+
+	if !found {
+		return fmt.Errorf("%w: none of them is gzip compressed", ErrNoDumps)
+	}
+
+	return nil
+}
+
+func buildIndex(ctx context.Context, fn string, span int64) (*Index, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close() //nolint:errcheck
+
+	size, mtime, sum, err := indexChecksum(fh)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &Index{size: size, mtime: mtime, sum: sum}
+	inf, err := newInflater(fh)
+	if err != nil {
+		return nil, err
+	}
+
+	// checkpoints waiting for the first full line following them
+	var pending []checkpoint
+	inf.onBlock = func(bitOff, out int64) {
+		last := idx.lastOut(pending)
+		if last >= 0 && out-last < span {
+			return
+		}
+		crc, member := inf.checksum()
+		pending = append(pending, checkpoint{
+			bitOff: bitOff,
+			out:    out,
+			window: inf.window(),
+			crc:    crc,
+			member: member,
+		})
+	}
+
+	bar := termio.NewProgressBar(size)
+	bar.Hidden = ctxutil.IsHidden(ctx)
+
+	var off int64
+	var lastHash string
+	rdr := bufio.NewReaderSize(inf, 1024*1024)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("user aborted")
+		default:
+		}
+
+		line, err := rdr.ReadString('\n')
+		if len(line) > 0 {
+			start := off
+			off += int64(len(line))
+
+			if hash, _, kind := parseLine(line); kind == lineEntry {
+				if hash < lastHash {
+					return nil, fmt.Errorf("dump is not ordered by hash (%s after %s)", hash, lastHash)
+				}
+				lastHash = hash
+
+				for len(pending) > 0 && pending[0].out <= start {
+					pending[0].hash = hash
+					idx.checkpoints = append(idx.checkpoints, pending[0])
+					pending = pending[1:]
+				}
+			}
+			bar.Set(inf.pos)
+		}
+		if err != nil {
+			if err == io.EOF { //nolint:errorlint
+				break
+			}
+
+			return nil, err
+		}
+	}
+	bar.Done()
+
+	if len(idx.checkpoints) < 1 {
+		return nil, fmt.Errorf("no entries found")
+	}
+
+	return idx, nil
+}
+
+// lastOut returns the uncompressed offset of the latest checkpoint or -1.
+func (idx *Index) lastOut(pending []checkpoint) int64 {
+	if len(pending) > 0 {
+		return pending[len(pending)-1].out
+	}
+	if len(idx.checkpoints) > 0 {
+		return idx.checkpoints[len(idx.checkpoints)-1].out
+	}
+
+	return -1
+}
+
+func (idx *Index) write(fn string) error {
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close() //nolint:errcheck
+
+	gzw := gzip.NewWriter(fh)
+	bw := bufio.NewWriter(gzw)
+	_, _ = bw.Write(indexMagic)
+	_ = binary.Write(bw, binary.BigEndian, idx.size)
+	_ = binary.Write(bw, binary.BigEndian, idx.mtime)
+	_, _ = bw.Write(idx.sum)
+	_ = binary.Write(bw, binary.BigEndian, uint64(len(idx.checkpoints)))
+	for _, cp := range idx.checkpoints {
+		sum, err := hex.DecodeString(cp.hash)
+		if err != nil {
+			return err
+		}
+		_ = binary.Write(bw, binary.BigEndian, cp.bitOff)
+		_ = binary.Write(bw, binary.BigEndian, cp.out)
+		_ = binary.Write(bw, binary.BigEndian, cp.crc)
+		_ = binary.Write(bw, binary.BigEndian, cp.member)
+		_, _ = bw.Write(sum)
+		_ = binary.Write(bw, binary.BigEndian, uint32(len(cp.window)))
+		_, _ = bw.Write(cp.window)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if err := gzw.Close(); err != nil {
+		return err
+	}
+
+	return fh.Close()
+}
+
+// loadIndex reads the checkpoint index of the given dump. It fails if there
+// is no index or if it doesn't match the dump, i.e. the size, the modification
+// time or the checksum of the start and end of the dump changed.
+func loadIndex(fn string) (*Index, error) {
+	dfh, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	size, mtime, sum, err := indexChecksum(dfh)
+	_ = dfh.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	fh, err := os.Open(IndexFilename(fn))
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close() //nolint:errcheck
+
+	gzr, err := gzip.NewReader(fh)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close() //nolint:errcheck
+
+	br := bufio.NewReader(gzr)
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(magic, indexMagic) {
+		return nil, fmt.Errorf("invalid index")
+	}
+
+	idx := &Index{}
+	var num uint64
+	if err := binary.Read(br, binary.BigEndian, &idx.size); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(br, binary.BigEndian, &idx.mtime); err != nil {
+		return nil, err
+	}
+	idx.sum = make([]byte, sha256.Size)
+	if _, err := io.ReadFull(br, idx.sum); err != nil {
+		return nil, err
+	}
+	if idx.size != size || idx.mtime != mtime || !bytes.Equal(idx.sum, sum) {
+		return nil, fmt.Errorf("index is outdated")
+	}
+	if err := binary.Read(br, binary.BigEndian, &num); err != nil {
+		return nil, err
+	}
+
+	hash := make([]byte, 20)
+	for range num {
+		var cp checkpoint
+		var wlen uint32
+		if err := binary.Read(br, binary.BigEndian, &cp.bitOff); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(br, binary.BigEndian, &cp.out); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(br, binary.BigEndian, &cp.crc); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(br, binary.BigEndian, &cp.member); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(br, hash); err != nil {
+			return nil, err
+		}
+		cp.hash = strings.ToUpper(hex.EncodeToString(hash))
+		if err := binary.Read(br, binary.BigEndian, &wlen); err != nil {
+			return nil, err
+		}
+		if wlen > windowSize {
+			return nil, fmt.Errorf("invalid index")
+		}
+		cp.window = make([]byte, wlen)
+		if _, err := io.ReadFull(br, cp.window); err != nil {
+			return nil, err
+		}
+		idx.checkpoints = append(idx.checkpoints, cp)
+	}
+
+	return idx, nil
+}
+
+// find returns the last checkpoint whose first hash is not greater than hash.
+func (idx *Index) find(hash string) int {
+	i := sort.Search(len(idx.checkpoints), func(i int) bool {
+		return idx.checkpoints[i].hash > hash
+	})
+
+	return max(i-1, 0)
+}
+
+// worthIt reports whether an indexed scan for the given sorted hashes visits few
+// enough checkpoints to be faster than streaming the whole dump.
+func (idx *Index) worthIt(in []string) bool {
+	visits := 0
+	last := -1
+	for _, hash := range in {
+		if c := idx.find(hash); c != last {
+			visits++
+			last = c
+		}
+	}
+
+	return float64(visits) <= indexMaxVisits*float64(len(idx.checkpoints))
+}
+
+// open returns a reader for the dump starting at the first full line following
+// the given checkpoint.
+func (idx *Index) open(fn string, i int) (io.ReadCloser, error) {
+	cp := idx.checkpoints[i]
+
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fh.Seek(cp.bitOff/8, io.SeekStart); err != nil {
+		_ = fh.Close()
+
+		return nil, err
+	}
+
+	inf, err := resumeInflater(fh, cp.bitOff, cp.out, cp.window, cp.crc, cp.member)
+	if err != nil {
+		_ = fh.Close()
+
+		return nil, err
+	}
+
+	rdr := bufio.NewReader(inf)
+	// skip the remainder of a line cut by the checkpoint
+	if len(cp.window) > 0 && cp.window[len(cp.window)-1] != '\n' {
+		if _, err := rdr.ReadString('\n'); err != nil {
+			_ = fh.Close()
+
+			return nil, err
+		}
+	}
+
+	return &dumpReader{Reader: rdr, closers: []io.Closer{fh}}, nil
+}
+
+// scanIndexedFile works like scanSortedFile but uses the checkpoint index to
+// only decompress the regions of the dump that may contain the input hashes.
+func (s *Scanner) scanIndexedFile(ctx context.Context, fn string, idx *Index, in []string, results chan match, stats *FileStats) {
+	debug.Log("Checking file %s using %d checkpoints ...\n", fn, len(idx.checkpoints))
+
+	i := 0
+	for i < len(in) {
+		c := idx.find(in[i])
+		end := ""
+		if c+1 < len(idx.checkpoints) {
+			end = idx.checkpoints[c+1].hash
+		}
+
+		rdr, err := idx.open(fn, c)
+		if err != nil {
+			stats.Err = fmt.Errorf("failed to seek in %s: %w", fn, err)
+
+			return
+		}
+
+		debug.Log("[%s] reading from checkpoint %d for %s", fn, c, in[i])
+		var seek bool
+		scanner := bufio.NewScanner(rdr)
+	SCAN:
+		for scanner.Scan() {
+			select {
+			case <-ctx.Done():
+				_ = rdr.Close()
+
+				return
+			default:
+			}
+
+			stats.Lines++
+			hash, count, kind := parseLine(scanner.Text())
+			switch kind {
+			case lineEntry:
+			case lineSkip:
+				continue
+			case lineMalformed:
+				// line numbers are relative to the checkpoint
+				if stats.malformed(ctx, stats.Lines, scanner.Bytes()) != nil {
+					_ = rdr.Close()
+
+					return
+				}
+
+				continue
+			}
+			for i < len(in) && in[i] < hash {
+				i++
+			}
+			if i < len(in) && in[i] == hash {
+				results <- match{hash: hash, count: count}
+				debug.Log("[%s] MATCH near checkpoint %d: %s", fn, c, hash)
+				i++
+			}
+			if i >= len(in) {
+				break SCAN
+			}
+			// the next input hash is located behind the next checkpoint
+			if end != "" && in[i] >= end {
+				seek = true
+
+				break SCAN
+			}
+		}
+		_ = rdr.Close()
+		if err := scanner.Err(); err != nil {
+			stats.Err = fmt.Errorf("failed to read %s: %w", fn, err)
+
+			return
+		}
+
+		if !seek {
+			// reached the end of the dump, no need to look for the remaining hashes
+			break
+		}
+	}
+
+	debug.Log("Finished checking file %s", fn)
+}
@@ -0,0 +1,239 @@
+package dump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSortedHashes(n int) []string {
+	hashes := make([]string, 0, n)
+	for i := range n {
+		hashes = append(hashes, fmt.Sprintf("%X", sha1.Sum([]byte(fmt.Sprintf("secret-%d", i)))))
+	}
+	sort.Strings(hashes)
+
+	return hashes
+}
+
+func testDump(hashes []string) []byte {
+	buf := &bytes.Buffer{}
+	for i, h := range hashes {
+		fmt.Fprintf(buf, "%s:%d\n", h, i%100+1)
+	}
+
+	return buf.Bytes()
+}
+
+func TestInflater(t *testing.T) {
+	t.Parallel()
+
+	want := testDump(testSortedHashes(20000))
+
+	for _, level := range []int{gzip.NoCompression, gzip.HuffmanOnly, gzip.BestSpeed, gzip.BestCompression} {
+		buf := &bytes.Buffer{}
+		gzw, err := gzip.NewWriterLevel(buf, level)
+		require.NoError(t, err)
+		gzw.Name = "dump.txt"
+		_, err = gzw.Write(want)
+		require.NoError(t, err)
+		require.NoError(t, gzw.Close())
+
+		// a second gzip member
+		gzw = gzip.NewWriter(buf)
+		_, err = gzw.Write([]byte("trailer\n"))
+		require.NoError(t, err)
+		require.NoError(t, gzw.Close())
+
+		inf, err := newInflater(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		got, err := io.ReadAll(inf)
+		require.NoError(t, err, "level %d", level)
+		assert.Equal(t, string(want)+"trailer\n", string(got), "level %d", level)
+
+		// a corrupt checksum in the trailer
+		corrupt := bytes.Clone(buf.Bytes())
+		corrupt[len(corrupt)-8] ^= 0xff
+		inf, err = newInflater(bytes.NewReader(corrupt))
+		require.NoError(t, err)
+		_, err = io.ReadAll(inf)
+		require.ErrorIs(t, err, errInflateChecksum, "level %d", level)
+	}
+
+	_, err := newInflater(strings.NewReader("not gzip"))
+	require.Error(t, err)
+}
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := ctxutil.WithHidden(t.Context(), true)
+
+	hashes := testSortedHashes(50000)
+	fn := filepath.Join(td, "dump.txt.gz")
+	require.NoError(t, testWriteGZ(fn, testDump(hashes)))
+
+	scanner, err := New(fn)
+	require.NoError(t, err)
+	require.NoError(t, scanner.BuildIndex(ctx, 64*1024))
+
+	idx, err := loadIndex(fn)
+	require.NoError(t, err)
+	assert.Greater(t, len(idx.checkpoints), 2)
+	for i, cp := range idx.checkpoints[1:] {
+		assert.Less(t, idx.checkpoints[i].hash, cp.hash)
+	}
+
+	want := []string{
+		hashes[0],
+		hashes[1],
+		hashes[12345],
+		hashes[25000],
+		hashes[25001],
+		hashes[len(hashes)-1],
+	}
+	in := append([]string{
+		"0000000000000000000000000000000000000000",
+		"FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF",
+		strings.ToLower(testSortedHashes(1)[0][:39]) + "X",
+	}, want...)
+	// the checkpoint hashes are the most likely to be missed
+	for _, cp := range idx.checkpoints[1:] {
+		want = append(want, cp.hash)
+		in = append(in, cp.hash)
+	}
+	sort.Strings(want)
+
+	got := scanner.LookupBatch(ctx, in)
+	sort.Strings(got)
+	assert.Equal(t, want, got)
+
+	// that many hashes are streamed, the index finds the same
+	assert.False(t, idx.worthIt(in))
+	assert.True(t, idx.worthIt([]string{hashes[0], hashes[12345]}))
+	results := make(chan match, len(in))
+	scanner.scanIndexedFile(ctx, fn, idx, in, results, &FileStats{File: fn})
+	close(results)
+	got = got[:0]
+	for m := range results {
+		got = append(got, m.hash)
+	}
+	sort.Strings(got)
+	assert.Equal(t, want, got)
+
+	// an outdated index is ignored
+	require.NoError(t, os.Remove(fn))
+	require.NoError(t, testWriteGZ(fn, testDump(hashes[:100])))
+	_, err = loadIndex(fn)
+	require.Error(t, err)
+	got = scanner.LookupBatch(ctx, []string{hashes[0], hashes[12345]})
+	assert.Equal(t, []string{hashes[0]}, got)
+
+	// unsorted dumps can not be indexed
+	require.NoError(t, os.Remove(fn))
+	require.NoError(t, testWriteGZ(fn, []byte(testHibpSampleUnsorted)))
+	require.Error(t, scanner.BuildIndex(ctx, 64))
+
+	// uncompressed dumps are skipped
+	fn = filepath.Join(td, "dump.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSampleSorted), 0o644))
+	scanner, err = New(fn)
+	require.NoError(t, err)
+	require.ErrorIs(t, scanner.BuildIndex(ctx, 64), ErrNoDumps)
+}
+
+func TestIndexOutdated(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := ctxutil.WithHidden(t.Context(), true)
+
+	writeDump := func(fn string, hashes []string) {
+		t.Helper()
+
+		buf := &bytes.Buffer{}
+		gzw, err := gzip.NewWriterLevel(buf, gzip.NoCompression)
+		require.NoError(t, err)
+		_, err = gzw.Write(testDump(hashes))
+		require.NoError(t, err)
+		require.NoError(t, gzw.Close())
+		require.NoError(t, os.WriteFile(fn, buf.Bytes(), 0o644))
+	}
+
+	hashes := testSortedHashes(20000)
+	fn := filepath.Join(td, "dump.txt.gz")
+	writeDump(fn, hashes[:10000])
+	fi, err := os.Stat(fn)
+	require.NoError(t, err)
+
+	scanner, err := New(fn)
+	require.NoError(t, err)
+	require.NoError(t, scanner.BuildIndex(ctx, 64*1024))
+	_, err = loadIndex(fn)
+	require.NoError(t, err)
+
+	// a different dump of the same size and modification time
+	writeDump(fn, hashes[10000:])
+	require.NoError(t, os.Chtimes(fn, fi.ModTime(), fi.ModTime()))
+	fi2, err := os.Stat(fn)
+	require.NoError(t, err)
+	require.Equal(t, fi.Size(), fi2.Size())
+	_, err = loadIndex(fn)
+	require.Error(t, err)
+	got := scanner.LookupBatch(ctx, []string{hashes[0], hashes[15000]})
+	assert.Equal(t, []string{hashes[15000]}, got)
+
+	// the same dump with a new modification time
+	require.NoError(t, scanner.BuildIndex(ctx, 64*1024))
+	later := fi.ModTime().Add(time.Hour)
+	require.NoError(t, os.Chtimes(fn, later, later))
+	_, err = loadIndex(fn)
+	require.Error(t, err)
+}
+
+// BenchmarkScanIndexed compares an indexed scan for a few hashes to streaming
+// the whole dump.
+func BenchmarkScanIndexed(b *testing.B) {
+	fn, size := benchmarkDump(b)
+	ctx := ctxutil.WithHidden(b.Context(), true)
+	s := &Scanner{dumps: []string{fn}}
+	require.NoError(b, s.BuildIndex(ctx, 64*1024))
+	idx, err := loadIndex(fn)
+	require.NoError(b, err)
+
+	hashes := testSortedHashes(200000)
+	in := []string{hashes[1000], hashes[90000], hashes[150000], hashes[len(hashes)-1]}
+	require.True(b, idx.worthIt(in))
+	results := make(chan match, len(in))
+
+	for _, bc := range []struct {
+		name string
+		scan func()
+	}{
+		{"streaming", func() { s.scanSortedFile(ctx, fn, in, results, &FileStats{File: fn}) }},
+		{"indexed", func() { s.scanIndexedFile(ctx, fn, idx, in, results, &FileStats{File: fn}) }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(size)
+			for b.Loop() {
+				bc.scan()
+				for len(results) > 0 {
+					<-results
+				}
+			}
+		})
+	}
+}
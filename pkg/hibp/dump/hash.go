@@ -0,0 +1,56 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+)
+
+// hexUpper are the digits used to encode hashes.
+const hexUpper = "0123456789ABCDEF"
+
+// hashSum is a binary SHA-1 sum. Comparing those is much cheaper than
+// comparing their hex representation.
+type hashSum [20]byte
+
+// unhex maps ASCII hex digits (either case) to their value. Everything else
+// maps to 0xff.
+var unhex = func() [256]byte {
+	var t [256]byte
+	for i := range t {
+		t[i] = 0xff
+	}
+	for i, c := range hexUpper {
+		t[c] = byte(i)
+		t[strings.ToLower(string(c))[0]] = byte(i)
+	}
+
+	return t
+}()
+
+// decodeHash decodes the first 40 hex digits of b into h without allocating.
+// It returns false if b is too short or contains invalid digits.
+func decodeHash(h *hashSum, b []byte) bool {
+	if len(b) < 40 {
+		return false
+	}
+	for i := range h {
+		hi, lo := unhex[b[2*i]], unhex[b[2*i+1]]
+		if hi > 0x0f || lo > 0x0f {
+			return false
+		}
+		h[i] = hi<<4 | lo
+	}
+
+	return true
+}
+
+// compare returns -1, 0 or +1 if h is smaller, equal or greater than o.
+func (h *hashSum) compare(o *hashSum) int {
+	return bytes.Compare(h[:], o[:])
+}
+
+// String returns the upper case hex representation of h.
+func (h hashSum) String() string {
+	return strings.ToUpper(hex.EncodeToString(h[:]))
+}
@@ -0,0 +1,597 @@
+package dump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// windowSize is the size of the DEFLATE history window.
+	windowSize = 32 * 1024
+	windowMask = windowSize - 1
+	// maxCodeBits is the longest Huffman code allowed by DEFLATE.
+	maxCodeBits = 15
+)
+
+var (
+	errInflateCorrupt  = errors.New("corrupt deflate stream")
+	errInflateChecksum = errors.New("gzip checksum mismatch")
+
+	lengthBase  = [...]uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [...]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [...]uint16{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [...]uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+	clenOrder   = [...]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+	fixedLit, fixedDist = fixedCodes()
+)
+
+type inflateState int
+
+const (
+	stateHeader inflateState = iota
+	stateStored
+	stateHuffman
+	stateDone
+)
+
+// huffman is a canonical Huffman code in the form used by zlib's puff.c.
+type huffman struct {
+	count  [maxCodeBits + 1]uint16
+	symbol []uint16
+}
+
+func newHuffman(lengths []uint8) (*huffman, error) {
+	h := &huffman{symbol: make([]uint16, len(lengths))}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	if int(h.count[0]) == len(lengths) {
+		// no codes, only valid for an unused distance code
+		return h, nil
+	}
+
+	left := 1
+	for l := 1; l <= maxCodeBits; l++ {
+		left <<= 1
+		left -= int(h.count[l])
+		if left < 0 {
+			return nil, errInflateCorrupt
+		}
+	}
+
+	var offs [maxCodeBits + 1]uint16
+	for l := 1; l < maxCodeBits; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+	for sym, l := range lengths {
+		if l != 0 {
+			h.symbol[offs[l]] = uint16(sym)
+			offs[l]++
+		}
+	}
+
+	return h, nil
+}
+
+func fixedCodes() (*huffman, *huffman) {
+	lengths := make([]uint8, 288)
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	lit, _ := newHuffman(lengths)
+
+	dists := make([]uint8, 30)
+	for i := range dists {
+		dists[i] = 5
+	}
+	dist, _ := newHuffman(dists)
+
+	return lit, dist
+}
+
+// inflater is a minimal gzip / DEFLATE (RFC 1951, RFC 1952) decoder. Unlike
+// compress/flate it reports the bit position of every block boundary and can
+// resume decompression at any of those positions, given the preceding 32 KiB
+// of output. This is what makes random access into gzip compressed dumps
+// possible. It favors simplicity over speed and is only used for building
+// and reading checkpoint indices.
+type inflater struct {
+	r     *bufio.Reader
+	pos   int64 // file offset of the next byte read from r
+	bits  uint64
+	nbits uint
+
+	hist   [windowSize]byte
+	hpos   int
+	hlen   int
+	out    int64
+	copied int
+	dist   int
+
+	// crc is the running (inverted) CRC-32 of the current gzip member and
+	// member its uncompressed offset. Both are verified against the trailer.
+	crc    uint32
+	member int64
+
+	state  inflateState
+	final  bool
+	stored int
+	lit    *huffman
+	dst    *huffman
+	err    error
+
+	// onBlock is called with the bit offset and uncompressed offset of
+	// every block header before it is read.
+	onBlock func(bitOff, out int64)
+}
+
+// newInflater creates a decoder for the gzip stream r starting at the
+// beginning of the file.
+func newInflater(r io.Reader) (*inflater, error) {
+	f := &inflater{
+		r:   bufio.NewReaderSize(r, 64*1024),
+		crc: ^uint32(0),
+	}
+	if err := f.readGzipHeader(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// resumeInflater creates a decoder continuing at the given block boundary.
+// r must be positioned at the byte containing bitOff, window must hold the
+// output preceding the block and out is the uncompressed offset of the block.
+// crc and member are the running checksum and the start of the gzip member at
+// that block, as returned by checksum.
+func resumeInflater(r io.Reader, bitOff, out int64, window []byte, crc uint32, member int64) (*inflater, error) {
+	f := &inflater{
+		r:      bufio.NewReaderSize(r, 64*1024),
+		pos:    bitOff / 8,
+		out:    out,
+		crc:    crc,
+		member: member,
+	}
+	if len(window) > windowSize {
+		return nil, fmt.Errorf("window too large")
+	}
+	f.hpos = copy(f.hist[:], window) & windowMask
+	f.hlen = len(window)
+	if _, err := f.need(uint(bitOff % 8)); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// bitOffset returns the offset of the next unread bit in the file.
+func (f *inflater) bitOffset() int64 {
+	return f.pos*8 - int64(f.nbits)
+}
+
+// checksum returns the running CRC-32 and the uncompressed offset of the
+// current gzip member.
+func (f *inflater) checksum() (uint32, int64) {
+	return f.crc, f.member
+}
+
+// window returns a copy of the last (up to) 32 KiB of output.
+func (f *inflater) window() []byte {
+	w := make([]byte, f.hlen)
+	start := (f.hpos - f.hlen + windowSize) & windowMask
+	n := copy(w, f.hist[start:min(start+f.hlen, windowSize)])
+	copy(w[n:], f.hist[:f.hlen-n])
+
+	return w
+}
+
+// need returns the next n bits of the stream (LSB first).
+func (f *inflater) need(n uint) (uint64, error) {
+	for f.nbits < n {
+		b, err := f.r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, io.ErrUnexpectedEOF
+			}
+
+			return 0, err
+		}
+		f.pos++
+		f.bits |= uint64(b) << f.nbits
+		f.nbits += 8
+	}
+	v := f.bits & (1<<n - 1)
+	f.bits >>= n
+	f.nbits -= n
+
+	return v, nil
+}
+
+// readByte returns the next byte of the stream. The stream must be byte
+// aligned.
+func (f *inflater) readByte() (byte, error) {
+	if f.nbits >= 8 {
+		b := byte(f.bits)
+		f.bits >>= 8
+		f.nbits -= 8
+
+		return b, nil
+	}
+	b, err := f.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	f.pos++
+
+	return b, nil
+}
+
+func (f *inflater) align() {
+	f.bits >>= f.nbits % 8
+	f.nbits -= f.nbits % 8
+}
+
+func (f *inflater) skipBytes(n int) error {
+	for range n {
+		if _, err := f.readByte(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *inflater) skipString() error {
+	for {
+		b, err := f.readByte()
+		if err != nil {
+			return err
+		}
+		if b == 0 {
+			return nil
+		}
+	}
+}
+
+// readGzipHeader reads a gzip member header. It returns io.EOF if there
+// are no more members.
+func (f *inflater) readGzipHeader() error {
+	hdr := make([]byte, 10)
+	for i := range hdr {
+		b, err := f.readByte()
+		if err != nil {
+			if i == 0 && errors.Is(err, io.EOF) {
+				return io.EOF
+			}
+
+			return io.ErrUnexpectedEOF
+		}
+		hdr[i] = b
+	}
+	if hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[2] != 8 {
+		return fmt.Errorf("invalid gzip header")
+	}
+
+	flg := hdr[3]
+	if flg&0x04 != 0 {
+		lo, err := f.readByte()
+		if err != nil {
+			return err
+		}
+		hi, err := f.readByte()
+		if err != nil {
+			return err
+		}
+		if err := f.skipBytes(int(lo) | int(hi)<<8); err != nil {
+			return err
+		}
+	}
+	if flg&0x08 != 0 {
+		if err := f.skipString(); err != nil {
+			return err
+		}
+	}
+	if flg&0x10 != 0 {
+		if err := f.skipString(); err != nil {
+			return err
+		}
+	}
+	if flg&0x02 != 0 {
+		return f.skipBytes(2)
+	}
+
+	return nil
+}
+
+func (f *inflater) decode(h *huffman) (int, error) {
+	code, first, index := 0, 0, 0
+	for l := 1; l <= maxCodeBits; l++ {
+		b, err := f.need(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= int(b)
+		count := int(h.count[l])
+		if code-count < first {
+			return int(h.symbol[index+(code-first)]), nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+
+	return 0, errInflateCorrupt
+}
+
+func (f *inflater) readDynamic() error {
+	v, err := f.need(14)
+	if err != nil {
+		return err
+	}
+	nlen := int(v&0x1f) + 257
+	ndist := int(v>>5&0x1f) + 1
+	ncode := int(v>>10) + 4
+	if nlen > 286 || ndist > 30 {
+		return errInflateCorrupt
+	}
+
+	lengths := make([]uint8, 19)
+	for i := range ncode {
+		l, err := f.need(3)
+		if err != nil {
+			return err
+		}
+		lengths[clenOrder[i]] = uint8(l)
+	}
+	lencode, err := newHuffman(lengths)
+	if err != nil {
+		return err
+	}
+
+	lengths = make([]uint8, nlen+ndist)
+	for i := 0; i < len(lengths); {
+		sym, err := f.decode(lencode)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+
+			continue
+		}
+
+		var l uint8
+		var rep uint64
+		switch sym {
+		case 16:
+			if i == 0 {
+				return errInflateCorrupt
+			}
+			l = lengths[i-1]
+			rep, err = f.need(2)
+			rep += 3
+		case 17:
+			rep, err = f.need(3)
+			rep += 3
+		default:
+			rep, err = f.need(7)
+			rep += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+int(rep) > len(lengths) {
+			return errInflateCorrupt
+		}
+		for range rep {
+			lengths[i] = l
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		return errInflateCorrupt
+	}
+
+	if f.lit, err = newHuffman(lengths[:nlen]); err != nil {
+		return err
+	}
+	if f.dst, err = newHuffman(lengths[nlen:]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// nextBlock reads the next block header, finishing the current gzip member
+// if the previous block was the final one.
+func (f *inflater) nextBlock() error {
+	if f.final {
+		f.align()
+		if err := f.readTrailer(); err != nil {
+			return err
+		}
+		if err := f.readGzipHeader(); err != nil {
+			if errors.Is(err, io.EOF) {
+				f.state = stateDone
+
+				return nil
+			}
+
+			return err
+		}
+		f.final = false
+		f.crc = ^uint32(0)
+		f.member = f.out
+	}
+
+	if f.onBlock != nil {
+		f.onBlock(f.bitOffset(), f.out)
+	}
+
+	v, err := f.need(3)
+	if err != nil {
+		return err
+	}
+	f.final = v&1 == 1
+
+	switch v >> 1 {
+	case 0:
+		f.align()
+		v, err := f.need(32)
+		if err != nil {
+			return err
+		}
+		if uint16(v) != ^uint16(v>>16) {
+			return errInflateCorrupt
+		}
+		f.stored = int(uint16(v))
+		f.state = stateStored
+	case 1:
+		f.lit, f.dst = fixedLit, fixedDist
+		f.state = stateHuffman
+	case 2:
+		if err := f.readDynamic(); err != nil {
+			return err
+		}
+		f.state = stateHuffman
+	default:
+		return errInflateCorrupt
+	}
+
+	return nil
+}
+
+// readTrailer verifies the CRC-32 and ISIZE of the gzip member.
+func (f *inflater) readTrailer() error {
+	var trailer [8]byte
+	for i := range trailer {
+		b, err := f.readByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		trailer[i] = b
+	}
+	if binary.LittleEndian.Uint32(trailer[:4]) != ^f.crc ||
+		binary.LittleEndian.Uint32(trailer[4:]) != uint32(f.out-f.member) {
+		return errInflateChecksum
+	}
+
+	return nil
+}
+
+func (f *inflater) emit(p []byte, n int, b byte) int {
+	p[n] = b
+	f.crc = crc32.IEEETable[byte(f.crc)^b] ^ f.crc>>8
+	f.hist[f.hpos] = b
+	f.hpos = (f.hpos + 1) & windowMask
+	f.hlen = min(f.hlen+1, windowSize)
+	f.out++
+
+	return n + 1
+}
+
+// Read implements io.Reader.
+func (f *inflater) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && f.err == nil {
+		if f.copied > 0 {
+			n = f.emit(p, n, f.hist[(f.hpos-f.dist)&windowMask])
+			f.copied--
+
+			continue
+		}
+
+		switch f.state {
+		case stateHeader:
+			f.err = f.nextBlock()
+		case stateStored:
+			if f.stored == 0 {
+				f.state = stateHeader
+
+				continue
+			}
+			b, err := f.readByte()
+			if err != nil {
+				f.err = io.ErrUnexpectedEOF
+
+				continue
+			}
+			n = f.emit(p, n, b)
+			f.stored--
+		case stateHuffman:
+			f.err = f.decodeSymbol(p, &n)
+		case stateDone:
+			f.err = io.EOF
+		}
+	}
+
+	if n > 0 && errors.Is(f.err, io.EOF) {
+		return n, nil
+	}
+
+	return n, f.err
+}
+
+func (f *inflater) decodeSymbol(p []byte, n *int) error {
+	sym, err := f.decode(f.lit)
+	if err != nil {
+		return err
+	}
+	switch {
+	case sym < 256:
+		*n = f.emit(p, *n, byte(sym))
+
+		return nil
+	case sym == 256:
+		f.state = stateHeader
+
+		return nil
+	}
+
+	sym -= 257
+	if sym >= len(lengthBase) {
+		return errInflateCorrupt
+	}
+	extra, err := f.need(uint(lengthExtra[sym]))
+	if err != nil {
+		return err
+	}
+	length := int(lengthBase[sym]) + int(extra)
+
+	sym, err = f.decode(f.dst)
+	if err != nil {
+		return err
+	}
+	if sym >= len(distBase) {
+		return errInflateCorrupt
+	}
+	extra, err = f.need(uint(distExtra[sym]))
+	if err != nil {
+		return err
+	}
+	dist := int(distBase[sym]) + int(extra)
+	if dist > f.hlen {
+		return errInflateCorrupt
+	}
+
+	f.copied = length
+	f.dist = dist
+
+	return nil
+}
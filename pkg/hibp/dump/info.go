@@ -0,0 +1,170 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Info holds statistics about a single dump.
+type Info struct {
+	File string
+	// Entries is the number of valid hashes.
+	Entries uint64
+	// Prevalence is the sum of all counts.
+	Prevalence uint64
+	// WithoutCount is the number of entries without a count (v1 format).
+	WithoutCount uint64
+	// Magnitudes is a histogram of the counts by order of magnitude. Index i
+	// holds the number of entries with a count in [10^i, 10^(i+1)).
+	Magnitudes []uint64
+	// Prefixes holds the number of entries per first hex digit.
+	Prefixes [16]uint64
+	// First and Last are the range prefixes (5 hex digits, as used by the
+	// API) of the lowest and highest hash in the file, wherever they are.
+	First string
+	Last  string
+	// Metadata are the "# key: value" comments at the beginning of the file
+	// and the fields of the metadata sidecar, if any.
+	Metadata map[string]string
+}
+
+// ReadInfo reads the whole dump and collects statistics about it.
+func ReadInfo(ctx context.Context, fn string) (*Info, error) {
+	meta, err := readHeader(fn)
+	if err != nil {
+		return nil, err
+	}
+	if sidecar, err := ReadMetadata(fn); err == nil {
+		for k, v := range sidecar.fields() {
+			meta[k] = v
+		}
+	}
+
+	info := &Info{
+		File:       fn,
+		Magnitudes: make([]uint64, 0, 8),
+		Metadata:   meta,
+	}
+
+	// track the lowest and highest hash instead of the first and last line,
+	// unsorted dumps would report a wrong range otherwise
+	var lowest, highest hashSum
+	if _, err := eachDumpEntry(ctx, fn, func(sum hashSum, count uint64) error {
+		if info.Entries == 0 || sum.compare(&lowest) < 0 {
+			lowest = sum
+		}
+		if info.Entries == 0 || sum.compare(&highest) > 0 {
+			highest = sum
+		}
+		info.Entries++
+		info.Prefixes[sum[0]>>4]++
+
+		if count == 0 {
+			info.WithoutCount++
+
+			return nil
+		}
+		info.Prevalence += count
+
+		mag := 0
+		for n := count; n >= 10; n /= 10 {
+			mag++
+		}
+		for len(info.Magnitudes) <= mag {
+			info.Magnitudes = append(info.Magnitudes, 0)
+		}
+		info.Magnitudes[mag]++
+
+		return nil
+	}); err != nil {
+		return info, err
+	}
+
+	if info.Entries > 0 {
+		info.First = lowest.String()[:5]
+		info.Last = highest.String()[:5]
+	}
+
+	return info, nil
+}
+
+// readHeader returns the "# key: value" comments at the beginning of a dump.
+func readHeader(fn string) (map[string]string, error) {
+	rdr, err := openDump(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rdr.Close()
+	}()
+
+	meta := make(map[string]string)
+	scanner := bufio.NewScanner(rdr)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '#' {
+			break
+		}
+
+		k, v, found := strings.Cut(string(line[1:]), ":")
+		if !found {
+			continue
+		}
+		meta[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	return meta, scanner.Err()
+}
+
+// String returns a human readable, multi-line report.
+func (i *Info) String() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "File:          %s\n", i.File)
+	fmt.Fprintf(sb, "Entries:       %d\n", i.Entries)
+	fmt.Fprintf(sb, "Prevalence:    %d\n", i.Prevalence)
+	if i.Entries > 0 {
+		fmt.Fprintf(sb, "Prefixes:      %s - %s\n", i.First, i.Last)
+	}
+
+	if len(i.Metadata) > 0 {
+		fmt.Fprintln(sb, "Metadata:")
+		keys := make([]string, 0, len(i.Metadata))
+		for k := range i.Metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(sb, "  %-20s %s\n", k+":", i.Metadata[k])
+		}
+	}
+
+	fmt.Fprintln(sb, "Counts:")
+	if i.WithoutCount > 0 {
+		fmt.Fprintf(sb, "  %-20s %12d\n", "unknown", i.WithoutCount)
+	}
+	// print the cumulative number of entries as well, it's the size of a dump
+	// filtered with this minimum count
+	var above uint64
+	for mag := len(i.Magnitudes) - 1; mag >= 0; mag-- {
+		above += i.Magnitudes[mag]
+		fmt.Fprintf(sb, "  >= %-17s %12d (%d in total)\n", "1"+strings.Repeat("0", mag), i.Magnitudes[mag], above)
+	}
+
+	fmt.Fprintln(sb, "Distribution:")
+	for p, n := range i.Prefixes {
+		share := 0.0
+		if i.Entries > 0 {
+			share = float64(n) / float64(i.Entries) * 100
+		}
+		fmt.Fprintf(sb, "  %X    %12d (%5.2f%%)\n", p, n, share)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,68 @@
+package dump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfo(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := t.Context()
+
+	fn := filepath.Join(td, "dump.txt")
+	require.NoError(t, os.WriteFile(fn, []byte("# source: https://example.org\n"+
+		"# just a comment\n"+
+		"# entries: 4\n"+
+		"00000000A8DAE4228F821FB418F59826079BF368:9\n"+
+		"00000000CAEF405439D57847A8657218C618160B:10\n"+
+		"F0000000CAEF405439D57847A8657218C618160B:2500\n"+
+		"FFFFFFFFCAEF405439D57847A8657218C618160B\n"+
+		"# trailing comment: ignored\n"), 0o644))
+
+	info, err := ReadInfo(ctx, fn)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), info.Entries)
+	assert.Equal(t, uint64(2519), info.Prevalence)
+	assert.Equal(t, uint64(1), info.WithoutCount)
+	assert.Equal(t, []uint64{1, 1, 0, 1}, info.Magnitudes)
+	assert.Equal(t, uint64(2), info.Prefixes[0])
+	assert.Equal(t, uint64(2), info.Prefixes[15])
+	assert.Equal(t, "00000", info.First)
+	assert.Equal(t, "FFFFF", info.Last)
+	assert.Equal(t, map[string]string{
+		"source":  "https://example.org",
+		"entries": "4",
+	}, info.Metadata)
+
+	out := info.String()
+	assert.Contains(t, out, "Prefixes:      00000 - FFFFF")
+	assert.Contains(t, out, "source:")
+	assert.Contains(t, out, ">= 1000")
+
+	// unsorted dump, the range is still the lowest and highest prefix
+	fn = filepath.Join(td, "unsorted.txt")
+	require.NoError(t, os.WriteFile(fn, []byte("80000000A8DAE4228F821FB418F59826079BF368:9\n"+
+		"FFFFFFFFCAEF405439D57847A8657218C618160B:10\n"+
+		"00000000CAEF405439D57847A8657218C618160B:2500\n"+
+		"40000000CAEF405439D57847A8657218C618160B:1\n"), 0o644))
+
+	info, err = ReadInfo(ctx, fn)
+	require.NoError(t, err)
+	assert.Equal(t, "00000", info.First)
+	assert.Equal(t, "FFFFF", info.Last)
+
+	// empty dump
+	fn = filepath.Join(td, "empty.txt.gz")
+	require.NoError(t, testWriteGZ(fn, nil))
+
+	info, err = ReadInfo(ctx, fn)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), info.Entries)
+	assert.Empty(t, info.First)
+}
@@ -0,0 +1,159 @@
+package dump
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gopasspw/gopass/pkg/appdir"
+	"github.com/gopasspw/gopass/pkg/debug"
+)
+
+// File is a dump in a dump directory.
+type File struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	// Created is when the dump was completed, see Created.
+	Created time.Time
+}
+
+// DefaultDir returns the default location of the managed dump directory.
+func DefaultDir() string {
+	return filepath.Join(appdir.New("gopass-hibp").UserData(), "dumps")
+}
+
+// ListDir returns all dumps in the given directory, newest (by Created)
+// first. Partial downloads, indexes and other auxiliary files are ignored.
+func ListDir(dir string) ([]File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]File, 0, len(entries))
+	for _, de := range entries {
+		if de.IsDir() || !isDumpName(de.Name()) {
+			continue
+		}
+
+		fi, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+		fn := filepath.Join(dir, de.Name())
+		created, err := Created(fn)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{
+			Path:    fn,
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+			Created: created,
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Created.After(files[j].Created)
+	})
+
+	return files, nil
+}
+
+func isDumpName(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, ext := range []string{".txt", ".gz", ".7z"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewestInDir returns the newest valid dump in the given directory.
+func NewestInDir(dir string) (string, error) {
+	files, err := ListDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range files {
+		if err := quickCheck(f.Path); err != nil {
+			debug.Log("skipping invalid dump %s: %s", f.Path, err)
+
+			continue
+		}
+
+		return f.Path, nil
+	}
+
+	return "", fmt.Errorf("no valid dumps found in %s", dir)
+}
+
+// quickCheck makes sure the dump can be opened and starts with a valid entry.
+// It does not read the whole dump, use Verify for that.
+func quickCheck(fn string) error {
+	rdr, err := openDump(fn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rdr.Close()
+	}()
+
+	var sum hashSum
+	scanner := bufio.NewScanner(rdr)
+	for scanner.Scan() {
+		switch _, kind := parseEntry(scanner.Bytes(), &sum); kind {
+		case lineEntry:
+			return nil
+		case lineSkip:
+			continue
+		case lineMalformed:
+			return fmt.Errorf("malformed line %q", scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("no entries found")
+}
+
+// PruneDir removes all but the newest keep dumps from the given directory,
+// including their indexes and metadata. It returns the removed dumps.
+func PruneDir(dir string, keep int) ([]File, error) {
+	files, err := ListDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if keep < 0 {
+		keep = 0
+	}
+	if len(files) <= keep {
+		return nil, nil
+	}
+
+	removed := make([]File, 0, len(files)-keep)
+	for _, f := range files[keep:] {
+		if err := os.Remove(f.Path); err != nil {
+			return removed, err
+		}
+		for _, aux := range []string{IndexFilename(f.Path), MetadataFilename(f.Path)} {
+			if err := os.Remove(aux); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+		}
+		removed = append(removed, f)
+	}
+
+	return removed, nil
+}
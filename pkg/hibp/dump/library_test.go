@@ -0,0 +1,73 @@
+package dump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibrary(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+
+	_, err := NewestInDir(td)
+	require.Error(t, err)
+
+	now := time.Now()
+	for i, name := range []string{"old.txt.gz", "mid.txt", "new.txt.gz", "broken.txt"} {
+		fn := filepath.Join(td, name)
+		content := []byte(testHibpSampleSorted)
+		if name == "broken.txt" {
+			content = []byte("not a dump\n")
+		}
+		if filepath.Ext(name) == ".gz" {
+			require.NoError(t, testWriteGZ(fn, content))
+		} else {
+			require.NoError(t, os.WriteFile(fn, content, 0o644))
+		}
+		mtime := now.Add(time.Duration(i-4) * time.Hour)
+		require.NoError(t, os.Chtimes(fn, mtime, mtime))
+	}
+	// auxiliary files are ignored
+	require.NoError(t, os.WriteFile(filepath.Join(td, "new.txt.gz.zidx"), []byte("index"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(td, "partial.txt.gz.tmp"), []byte("partial"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(td, ".hibp-dl"), 0o755))
+
+	files, err := ListDir(td)
+	require.NoError(t, err)
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, filepath.Base(f.Path))
+	}
+	assert.Equal(t, []string{"broken.txt", "new.txt.gz", "mid.txt", "old.txt.gz"}, names)
+
+	// the completion time of the metadata wins over the modification time
+	require.NoError(t, WriteMetadata(filepath.Join(td, "old.txt.gz"), &Metadata{Finished: now}))
+	files, err = ListDir(td)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(td, "old.txt.gz"), files[0].Path)
+	assert.True(t, now.Equal(files[0].Created))
+	require.NoError(t, os.Remove(MetadataFilename(filepath.Join(td, "old.txt.gz"))))
+
+	// the newest dump is broken
+	newest, err := NewestInDir(td)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(td, "new.txt.gz"), newest)
+
+	removed, err := PruneDir(td, 2)
+	require.NoError(t, err)
+	assert.Len(t, removed, 2)
+	assert.FileExists(t, filepath.Join(td, "new.txt.gz.zidx"))
+	assert.NoFileExists(t, filepath.Join(td, "old.txt.gz"))
+
+	removed, err = PruneDir(td, 1)
+	require.NoError(t, err)
+	assert.Len(t, removed, 1)
+	assert.NoFileExists(t, filepath.Join(td, "new.txt.gz"))
+	assert.NoFileExists(t, filepath.Join(td, "new.txt.gz.zidx"))
+}
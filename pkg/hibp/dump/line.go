@@ -0,0 +1,133 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+)
+
+type lineKind int
+
+const (
+	// lineEntry is a valid hash with an optional count.
+	lineEntry lineKind = iota
+	// lineSkip is a blank line or a comment.
+	lineSkip
+	// lineMalformed is anything else, e.g. a truncated line.
+	lineMalformed
+)
+
+type ctxKeyStrict int
+
+// WithStrict returns a context that makes the scanner fail on the first
+// malformed line instead of skipping it.
+func WithStrict(ctx context.Context, strict bool) context.Context {
+	return context.WithValue(ctx, ctxKeyStrict(0), strict)
+}
+
+// IsStrict returns true if malformed lines should be treated as an error.
+func IsStrict(ctx context.Context) bool {
+	bv, ok := ctx.Value(ctxKeyStrict(0)).(bool)
+
+	return ok && bv
+}
+
+// parseEntry parses a single dump line into sum and returns the prevalence
+// count (zero for v1 dumps without counts). It accepts upper and lower case hex,
+// surrounding white space and CRLF line endings. Blank lines and lines starting
+// with '#' are skipped. It does not allocate.
+func parseEntry(line []byte, sum *hashSum) (uint64, lineKind) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return 0, lineSkip
+	}
+	if len(line) < 40 || !decodeHash(sum, line) {
+		return 0, lineMalformed
+	}
+
+	rest := line[40:]
+	if len(rest) == 0 {
+		return 0, lineEntry
+	}
+	if len(rest) < 2 || rest[0] != ':' {
+		return 0, lineMalformed
+	}
+
+	var count uint64
+	for _, c := range rest[1:] {
+		if c < '0' || c > '9' || count > (math.MaxUint64-9)/10 {
+			return 0, lineMalformed
+		}
+		count = count*10 + uint64(c-'0')
+	}
+
+	return count, lineEntry
+}
+
+// parseLine works like parseEntry but returns the upper case hex hash.
+func parseLine(line string) (string, uint64, lineKind) {
+	var sum hashSum
+	count, kind := parseEntry([]byte(line), &sum)
+	if kind != lineEntry {
+		return "", 0, kind
+	}
+
+	return sum.String(), count, kind
+}
+
+// FileStats summarizes the lines seen while scanning a dump.
+type FileStats struct {
+	File string
+	// Lines is the number of lines read.
+	Lines uint64
+	// Malformed is the number of lines that could not be parsed.
+	Malformed uint64
+	// FirstMalformed is the line number of the first malformed line.
+	FirstMalformed uint64
+	// Err is set if the scan of this file did not complete.
+	Err error
+}
+
+// malformed records a malformed line. It returns an error if the scan should
+// be aborted.
+func (f *FileStats) malformed(ctx context.Context, lineNo uint64, line []byte) error {
+	f.Malformed++
+	if f.FirstMalformed == 0 {
+		f.FirstMalformed = lineNo
+	}
+	if !IsStrict(ctx) {
+		return nil
+	}
+
+	if len(line) > 64 {
+		line = line[:64]
+	}
+	f.Err = fmt.Errorf("%s:%d: malformed line %q", f.File, lineNo, line)
+
+	return f.Err
+}
+
+// add merges the statistics of a part of the same file.
+func (f *FileStats) add(o FileStats) {
+	f.Lines += o.Lines
+	f.Malformed += o.Malformed
+	if o.FirstMalformed > 0 && (f.FirstMalformed == 0 || o.FirstMalformed < f.FirstMalformed) {
+		f.FirstMalformed = o.FirstMalformed
+	}
+	if f.Err == nil {
+		f.Err = o.Err
+	}
+}
+
+// String returns a short human readable summary.
+func (f FileStats) String() string {
+	if f.Err != nil {
+		return fmt.Sprintf("%s: %s", f.File, f.Err)
+	}
+	if f.Malformed > 0 {
+		return fmt.Sprintf("%s: skipped %d of %d lines as malformed (first at line %d)", f.File, f.Malformed, f.Lines, f.FirstMalformed)
+	}
+
+	return fmt.Sprintf("%s: %d lines", f.File, f.Lines)
+}
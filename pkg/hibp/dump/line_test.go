@@ -0,0 +1,39 @@
+package dump
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEntry(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		line  string
+		count uint64
+		kind  lineKind
+	}{
+		{"00000000A8DAE4228F821FB418F59826079BF368:42", 42, lineEntry},
+		{"00000000a8dae4228f821fb418f59826079bf368:42\r", 42, lineEntry},
+		{"  00000000A8DAE4228F821FB418F59826079BF368  ", 0, lineEntry},
+		{"00000000A8DAE4228F821FB418F59826079BF368", 0, lineEntry},
+		{"", 0, lineSkip},
+		{"\r", 0, lineSkip},
+		{"# comment", 0, lineSkip},
+		{"00000000A8DAE4228F821FB418F59826079BF3", 0, lineMalformed},
+		{"00000000A8DAE4228F821FB418F59826079BF368:", 0, lineMalformed},
+		{"00000000A8DAE4228F821FB418F59826079BF368:4x", 0, lineMalformed},
+		{"00000000A8DAE4228F821FB418F59826079BF368:99999999999999999999999", 0, lineMalformed},
+		{"00000000A8DAE4228F821FB418F59826079BF368X42", 0, lineMalformed},
+		{"0000000ZA8DAE4228F821FB418F59826079BF368:42", 0, lineMalformed},
+	} {
+		var sum hashSum
+		count, kind := parseEntry([]byte(tc.line), &sum)
+		assert.Equal(t, tc.kind, kind, tc.line)
+		assert.Equal(t, tc.count, count, tc.line)
+		if kind == lineEntry {
+			assert.Equal(t, "00000000A8DAE4228F821FB418F59826079BF368", sum.String())
+		}
+	}
+}
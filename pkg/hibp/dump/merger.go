@@ -1,99 +1,513 @@
 package dump
 
 import (
+	"bufio"
 	"compress/gzip"
+	"container/heap"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/termio"
 )
 
-func (s *Scanner) Merge(ctx context.Context, outfile string) error { //nolint:cyclop
+// MergeStrategy decides which count is used for hashes contained in more than
+// one input.
+type MergeStrategy string
+
+const (
+	// MergeMax uses the highest count.
+	MergeMax MergeStrategy = "max"
+	// MergeSum adds up all counts.
+	MergeSum MergeStrategy = "sum"
+	// MergeLeft uses the count of the first input containing the hash.
+	MergeLeft MergeStrategy = "left"
+	// MergeRight uses the count of the last input containing the hash.
+	MergeRight MergeStrategy = "right"
+	// MergeNewest uses the count of the most recently modified input.
+	MergeNewest MergeStrategy = "newest"
+)
+
+// MergeStrategies lists all supported merge strategies.
+var MergeStrategies = []MergeStrategy{MergeMax, MergeSum, MergeLeft, MergeRight, MergeNewest}
+
+// ParseMergeStrategy returns the merge strategy with the given name.
+func ParseMergeStrategy(name string) (MergeStrategy, error) {
+	for _, ms := range MergeStrategies {
+		if string(ms) == name {
+			return ms, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown merge strategy %q", name)
+}
+
+// MergeReport summarizes a merge relative to the first input.
+type MergeReport struct {
+	// Entries is the number of hashes written.
+	Entries uint64
+	// Added is the number of hashes not contained in the first input.
+	Added uint64
+	// Unchanged is the number of hashes with the same count as in the first input.
+	Unchanged uint64
+	// Changed is the number of hashes whose count differs from the first input.
+	Changed uint64
+	// Duplicates is the number of repeated hashes within an input. They are
+	// folded into one entry with the highest count before the inputs are
+	// combined.
+	Duplicates uint64
+}
+
+// String returns a short human readable summary.
+func (r MergeReport) String() string {
+	return fmt.Sprintf("%d hashes: %d added, %d unchanged, %d with changed counts", r.Entries, r.Added, r.Unchanged, r.Changed)
+}
+
+// Merge merges all dumps of this scanner into a single gzip compressed dump
+// ordered by hash. All inputs must be ordered by hash. The strategy decides
+// which count is written for hashes contained in more than one input. Inputs
+// without counts (v1 dumps) never override a known count.
+func (s *Scanner) Merge(ctx context.Context, outfile string, strategy MergeStrategy) (*MergeReport, error) {
+	if _, err := ParseMergeStrategy(string(strategy)); err != nil {
+		return nil, err
+	}
 	for _, dump := range s.dumps {
 		if !isSorted(dump) {
-			return fmt.Errorf("merging unsorted input files is not supported")
+			return nil, fmt.Errorf("merging unsorted input files is not supported")
 		}
 	}
-	if len(s.dumps) != 2 {
-		return fmt.Errorf("nothing to merge")
-	}
 	if !strings.HasSuffix(outfile, ".gz") {
 		outfile += ".gz"
 	}
 
 	fmt.Printf("Merging %+v into %s\n", s.dumps, outfile)
-	fh, err := os.OpenFile(outfile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+
+	report, err := mergeFiles(ctx, s.dumps, outfile, strategy, gzip.DefaultCompression, &Metadata{
+		Source: fmt.Sprintf("merge (%s)", strategy),
+		Inputs: s.dumps,
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer fh.Close() //nolint:errcheck
 
-	gzw := gzip.NewWriter(fh)
-	defer gzw.Close() //nolint:errcheck
+	fmt.Printf("Wrote %s to %s\n", report, outfile)
 
-	resLeft := make(chan string, 1024)
-	resRight := make(chan string, 1024)
-	go func() {
-		s.scanSortedFile(ctx, s.dumps[0], nil, resLeft)
-		close(resLeft)
-	}()
-	go func() {
-		s.scanSortedFile(ctx, s.dumps[1], nil, resRight)
-		close(resRight)
+	return report, nil
+}
+
+// mergeFiles merges the sorted dumps into outfile.
+func mergeFiles(ctx context.Context, dumps []string, outfile string, strategy MergeStrategy, level int, meta *Metadata) (*MergeReport, error) {
+	sources := make(mergeHeap, 0, len(dumps))
+	defer func() {
+		for _, src := range sources {
+			src.close()
+		}
 	}()
+	for i, fn := range dumps {
+		src, err := newMergeSource(ctx, fn, i)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := src.next()
+		if err != nil {
+			src.close()
 
-	for {
-		lv, lok := <-resLeft
-		rv, rok := <-resRight
-		if !lok && !rok {
-			// all done
-			break
-		}
-		// left needs to catch up
-		for lv[:40] < rv[:40] || !rok {
-			fmt.Fprintln(gzw, lv)
-			lv, lok = <-resLeft
-			if !lok {
-				break
+			return nil, err
+		}
+		if !ok {
+			// empty input
+			src.close()
+
+			continue
+		}
+		sources = append(sources, src)
+	}
+	heap.Init(&sources)
+
+	// rank the inputs by modification time for the newest strategy
+	newest := make([]int, len(dumps))
+	for _, src := range sources {
+		for _, other := range sources {
+			if src.mtime.After(other.mtime) {
+				newest[src.idx]++
 			}
 		}
-		// right needs to catch up
-		for rv[:40] < lv[:40] || !lok {
-			fmt.Fprintln(gzw, rv)
-			rv, rok = <-resRight
-			if !rok {
-				break
+	}
+
+	w, err := newDumpWriter(outfile, level, meta)
+	if err != nil {
+		return nil, err
+	}
+	defer w.abort()
+
+	// the output is sorted, so the first two bytes of the current hash are a
+	// good estimate of the progress
+	bar := termio.NewProgressBar(1 << 16)
+	bar.Hidden = ctxutil.IsHidden(ctx)
+
+	report := &MergeReport{}
+	duplicates := make([]uint64, len(dumps))
+	candidates := make([]mergeCandidate, 0, len(sources))
+	for len(sources) > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("user aborted")
+		default:
+		}
+
+		// collect the smallest hash from all inputs
+		sum := sources[0].sum
+		candidates = candidates[:0]
+		for len(sources) > 0 && sources[0].sum == sum {
+			src := sources[0]
+			if i := slices.IndexFunc(candidates, func(c mergeCandidate) bool { return c.idx == src.idx }); i >= 0 {
+				// a hash repeated within an input must not be counted twice
+				candidates[i].count = max(candidates[i].count, src.count)
+				duplicates[src.idx]++
+			} else {
+				candidates = append(candidates, mergeCandidate{idx: src.idx, count: src.count})
 			}
+
+			ok, err := src.next()
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				heap.Fix(&sources, 0)
+
+				continue
+			}
+
+			// this input ran out, continue with the others
+			heap.Pop(&sources)
+			src.close()
 		}
-		if lv[:40] == rv[:40] {
-			maxVal := max(rv[41:], lv[41:])
-			fmt.Fprintf(gzw, "%s:%s\n", lv[:40], maxVal)
 
-			continue
+		count := resolveCount(strategy, candidates, newest)
+		report.add(candidates, count)
+
+		if err := w.write(sum, count); err != nil {
+			return nil, err
 		}
-		if lok && !rok {
-			fmt.Fprintln(gzw, lv)
+		bar.Set(int64(sum[0])<<8 | int64(sum[1]))
+	}
+	bar.Done()
 
-			continue
+	for i, n := range duplicates {
+		if n > 0 {
+			fmt.Printf("Warning: %s contains %d duplicate hashes, using the highest count\n", dumps[i], n)
 		}
-		if !lok && rok {
-			fmt.Fprintln(gzw, rv)
+		report.Duplicates += n
+	}
+
+	if err := w.commit(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// mergeCandidate is the count of a hash in a single input.
+type mergeCandidate struct {
+	idx   int
+	count uint64
+}
 
+// resolveCount picks the count for a hash. Unknown counts (zero) are ignored
+// unless no input has a count.
+func resolveCount(strategy MergeStrategy, candidates []mergeCandidate, newest []int) uint64 {
+	var count uint64
+	var best int
+	found := false
+	for _, c := range candidates {
+		if c.count == 0 {
 			continue
 		}
-		if lv[:40] < rv[:40] {
-			fmt.Fprintln(gzw, lv)
-			fmt.Fprintln(gzw, rv)
 
+		switch strategy {
+		case MergeMax:
+			count = max(count, c.count)
+		case MergeSum:
+			count += c.count
+		case MergeLeft:
+			if !found || c.idx < best {
+				best, count = c.idx, c.count
+			}
+		case MergeRight:
+			if !found || c.idx > best {
+				best, count = c.idx, c.count
+			}
+		case MergeNewest:
+			if !found || newest[c.idx] > newest[best] {
+				best, count = c.idx, c.count
+			}
+		}
+		found = true
+	}
+
+	return count
+}
+
+func (r *MergeReport) add(candidates []mergeCandidate, count uint64) {
+	r.Entries++
+	for _, c := range candidates {
+		if c.idx != 0 {
 			continue
 		}
-		if lv[:40] < rv[:40] {
-			fmt.Fprintln(gzw, rv)
-			fmt.Fprintln(gzw, lv)
+		if c.count == count {
+			r.Unchanged++
+		} else {
+			r.Changed++
+		}
+
+		return
+	}
+	r.Added++
+}
+
+// mergeSourceBuffers is the number of chunk buffers read ahead for each
+// input of a merge.
+const mergeSourceBuffers = 2
+
+// mergeSourceMemory is an estimate of the memory used by each input of a
+// merge, i.e. its buffers and the decompression window.
+const mergeSourceMemory = mergeSourceBuffers*chunkSize + windowSize
+
+// mergeSource is a sorted input of the merge.
+type mergeSource struct {
+	ctx   context.Context //nolint:containedctx
+	fn    string
+	idx   int
+	mtime time.Time
+	rdr   io.ReadCloser
+	cr    *chunkReader
+	ch    chunk
+	rest  []byte
+	stats FileStats
+
+	sum   hashSum
+	count uint64
+}
+
+func newMergeSource(ctx context.Context, fn string, idx int) (*mergeSource, error) {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	rdr, err := openDump(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mergeSource{
+		ctx:   ctx,
+		fn:    fn,
+		idx:   idx,
+		mtime: fi.ModTime(),
+		rdr:   rdr,
+		cr:    newChunkReader(ctx, rdr, mergeSourceBuffers),
+		stats: FileStats{File: fn},
+	}, nil
+}
+
+// next advances to the next entry. It returns false if the input is exhausted.
+func (m *mergeSource) next() (bool, error) {
+	prev := m.sum
+	first := m.stats.Lines == 0
+
+	for {
+		if len(m.rest) == 0 {
+			var ok bool
+			m.ch, ok = m.cr.Next(m.ch)
+			if !ok {
+				if err := m.cr.Err(); err != nil {
+					return false, fmt.Errorf("failed to read %s: %w", m.fn, err)
+				}
+
+				return false, nil
+			}
+			m.rest = m.ch.buf
+		}
+
+		var line []byte
+		line, m.rest = nextLine(m.rest)
+		m.stats.Lines++
+
+		count, kind := parseEntry(line, &m.sum)
+		switch kind {
+		case lineEntry:
+		case lineSkip:
+			continue
+		case lineMalformed:
+			if err := m.stats.malformed(m.ctx, m.stats.Lines, line); err != nil {
+				return false, err
+			}
 
 			continue
 		}
+
+		if !first && m.sum.compare(&prev) < 0 {
+			return false, fmt.Errorf("%s:%d: input is not ordered by hash", m.fn, m.stats.Lines)
+		}
+		m.count = count
+
+		return true, nil
+	}
+}
+
+func (m *mergeSource) close() {
+	if m.cr == nil {
+		return
+	}
+	m.cr.Close()
+	_ = m.rdr.Close()
+	m.cr = nil
+}
+
+// mergeHeap is a min-heap of merge sources ordered by their current hash.
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int           { return len(h) }
+func (h mergeHeap) Less(i, j int) bool { return h[i].sum.compare(&h[j].sum) < 0 }
+func (h mergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) {
+	*h = append(*h, x.(*mergeSource)) //nolint:forcetypeassert
+}
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+
+	return x
+}
+
+// dumpWriter writes a gzip compressed dump to a temporary file and moves it
+// into place on commit. It makes sure the output is strictly ordered by hash.
+// If meta is set a metadata sidecar is written on commit as well.
+type dumpWriter struct {
+	fn       string
+	fh       *os.File
+	gzw      *gzip.Writer
+	bw       *bufio.Writer
+	checksum hash.Hash
+	meta     *Metadata
+	last     hashSum
+	entries  uint64
+	prefixes uint64
+	buf      []byte
+}
+
+func newDumpWriter(fn string, level int, meta *Metadata) (*dumpWriter, error) {
+	fh, err := os.OpenFile(fn+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	checksum := sha256.New()
+	gzw, err := gzip.NewWriterLevel(io.MultiWriter(fh, checksum), level)
+	if err != nil {
+		_ = fh.Close()
+
+		return nil, err
+	}
+	if meta != nil && meta.Started.IsZero() {
+		meta.Started = time.Now()
+	}
+	// derived dumps are as old as their oldest input. Look it up before the
+	// output replaces one of the inputs.
+	if meta != nil && meta.Finished.IsZero() {
+		meta.Finished = oldestCreated(meta.Inputs)
+	}
+
+	return &dumpWriter{
+		fn:       fn,
+		fh:       fh,
+		gzw:      gzw,
+		bw:       bufio.NewWriterSize(gzw, 1024*1024),
+		checksum: checksum,
+		meta:     meta,
+		buf:      make([]byte, 0, 64),
+	}, nil
+}
+
+// write adds a single entry. A count of zero is omitted (v1 format).
+func (w *dumpWriter) write(sum hashSum, count uint64) error {
+	if w.entries > 0 && sum.compare(&w.last) <= 0 {
+		return fmt.Errorf("output not strictly ordered by hash: %s after %s", sum, w.last)
+	}
+	if w.entries == 0 || hashPrefix(&sum) != hashPrefix(&w.last) {
+		w.prefixes++
+	}
+	w.last = sum
+	w.entries++
+
+	w.buf = w.buf[:0]
+	for _, b := range sum {
+		w.buf = append(w.buf, hexUpper[b>>4], hexUpper[b&0x0f])
 	}
+	if count > 0 {
+		w.buf = append(w.buf, ':')
+		w.buf = strconv.AppendUint(w.buf, count, 10)
+	}
+	w.buf = append(w.buf, '\n')
+
+	_, err := w.bw.Write(w.buf)
+
+	return err
+}
 
-	return nil
+// commit flushes all data and moves the output into place.
+func (w *dumpWriter) commit() error {
+	if w.fh == nil {
+		return fmt.Errorf("already closed")
+	}
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	if err := w.gzw.Close(); err != nil {
+		return err
+	}
+	if err := w.fh.Close(); err != nil {
+		return err
+	}
+	w.fh = nil
+
+	if err := os.Rename(w.fn+".tmp", w.fn); err != nil {
+		return err
+	}
+	if w.meta == nil {
+		return nil
+	}
+
+	w.meta.HashMode = HashModeSHA1
+	if w.meta.Finished.IsZero() {
+		w.meta.Finished = time.Now()
+	}
+	w.meta.Entries = w.entries
+	w.meta.Prefixes = w.prefixes
+	w.meta.SHA256 = hex.EncodeToString(w.checksum.Sum(nil))
+
+	return WriteMetadata(w.fn, w.meta)
+}
+
+// abort removes the temporary output unless it has been committed.
+func (w *dumpWriter) abort() {
+	if w.fh == nil {
+		return
+	}
+	_ = w.fh.Close()
+	_ = os.Remove(w.fn + ".tmp")
+	w.fh = nil
 }
@@ -0,0 +1,183 @@
+package dump
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReadGZ(t *testing.T, fn string) string {
+	t.Helper()
+
+	fh, err := os.Open(fn)
+	require.NoError(t, err)
+	defer fh.Close() //nolint:errcheck
+
+	gzr, err := gzip.NewReader(fh)
+	require.NoError(t, err)
+	buf, err := io.ReadAll(gzr)
+	require.NoError(t, err)
+
+	return string(buf)
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := ctxutil.WithHidden(t.Context(), true)
+
+	inputs := map[string]string{
+		"a.txt": "0000000000000000000000000000000000000001:9\n" +
+			"0000000000000000000000000000000000000003:10\n" +
+			"0000000000000000000000000000000000000005:1\n",
+		"b.txt": "0000000000000000000000000000000000000002:2\n" +
+			"0000000000000000000000000000000000000003:9\n",
+		"c.txt": "0000000000000000000000000000000000000003\n" +
+			"0000000000000000000000000000000000000004:4\n" +
+			"0000000000000000000000000000000000000006:6\n" +
+			"0000000000000000000000000000000000000007:7\n",
+		"d.txt": "# empty\n",
+	}
+	files := make([]string, 0, len(inputs))
+	for name, content := range inputs {
+		fn := filepath.Join(td, name)
+		require.NoError(t, os.WriteFile(fn, []byte(content), 0o644))
+		files = append(files, fn)
+	}
+
+	scanner, err := New(files...)
+	require.NoError(t, err)
+
+	out := filepath.Join(td, "merged")
+	_, err = scanner.Merge(ctx, out, "foo")
+	require.Error(t, err)
+	_, err = scanner.Merge(ctx, out, MergeMax)
+	require.NoError(t, err)
+	assert.Equal(t, "0000000000000000000000000000000000000001:9\n"+
+		"0000000000000000000000000000000000000002:2\n"+
+		"0000000000000000000000000000000000000003:10\n"+
+		"0000000000000000000000000000000000000004:4\n"+
+		"0000000000000000000000000000000000000005:1\n"+
+		"0000000000000000000000000000000000000006:6\n"+
+		"0000000000000000000000000000000000000007:7\n", testReadGZ(t, out+".gz"))
+
+	// a single input is normalized
+	scanner, err = New(filepath.Join(td, "c.txt"))
+	require.NoError(t, err)
+	_, err = scanner.Merge(ctx, out, MergeMax)
+	require.NoError(t, err)
+	assert.Equal(t, strings.ToUpper(inputs["c.txt"]), testReadGZ(t, out+".gz"))
+}
+
+func TestMergeUnsorted(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := ctxutil.WithHidden(t.Context(), true)
+
+	// unsorted right from the start
+	fn := filepath.Join(td, "unsorted.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSampleUnsorted), 0o644))
+	scanner, err := New(fn)
+	require.NoError(t, err)
+	_, err = scanner.Merge(ctx, filepath.Join(td, "out.gz"), MergeMax)
+	require.Error(t, err)
+
+	// unsorted later on
+	hashes := testSortedHashes(200)
+	hashes[150], hashes[151] = hashes[151], hashes[150]
+	fn = filepath.Join(td, "late.txt")
+	require.NoError(t, os.WriteFile(fn, testDump(hashes), 0o644))
+	scanner, err = New(fn)
+	require.NoError(t, err)
+	_, err = scanner.Merge(ctx, filepath.Join(td, "out.gz"), MergeMax)
+	require.Error(t, err)
+
+	_, err = os.Stat(filepath.Join(td, "out.gz"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(filepath.Join(td, "out.gz.tmp"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestMergeStrategies(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := ctxutil.WithHidden(t.Context(), true)
+
+	left := filepath.Join(td, "left.txt")
+	require.NoError(t, os.WriteFile(left, []byte(""+
+		"0000000000000000000000000000000000000001:9\n"+
+		"0000000000000000000000000000000000000002:2\n"+
+		"0000000000000000000000000000000000000003\n"+
+		"0000000000000000000000000000000000000004:4\n"), 0o644))
+	right := filepath.Join(td, "right.txt")
+	require.NoError(t, os.WriteFile(right, []byte(""+
+		"0000000000000000000000000000000000000001:10\n"+
+		"0000000000000000000000000000000000000002:2\n"+
+		"0000000000000000000000000000000000000003:3\n"+
+		"0000000000000000000000000000000000000005\n"), 0o644))
+	// make left the newest input
+	now := time.Now()
+	require.NoError(t, os.Chtimes(right, now.Add(-time.Hour), now.Add(-time.Hour)))
+
+	scanner, err := New(left, right)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		strategy MergeStrategy
+		counts   []string
+	}{
+		{MergeMax, []string{":10", ":2", ":3", ":4", ""}},
+		{MergeSum, []string{":19", ":4", ":3", ":4", ""}},
+		{MergeLeft, []string{":9", ":2", ":3", ":4", ""}},
+		{MergeRight, []string{":10", ":2", ":3", ":4", ""}},
+		{MergeNewest, []string{":9", ":2", ":3", ":4", ""}},
+	} {
+		out := filepath.Join(td, string(tc.strategy)+".gz")
+		report, err := scanner.Merge(ctx, out, tc.strategy)
+		require.NoError(t, err)
+
+		want := ""
+		for i, c := range tc.counts {
+			want += fmt.Sprintf("000000000000000000000000000000000000000%d%s\n", i+1, c)
+		}
+		assert.Equal(t, want, testReadGZ(t, out), tc.strategy)
+		assert.Equal(t, uint64(5), report.Entries, tc.strategy)
+		assert.Equal(t, uint64(1), report.Added, tc.strategy)
+	}
+
+	report, err := scanner.Merge(ctx, filepath.Join(td, "max.gz"), MergeMax)
+	require.NoError(t, err)
+	assert.Equal(t, MergeReport{Entries: 5, Added: 1, Unchanged: 2, Changed: 2}, *report)
+
+	// hashes repeated within an input are folded first, even when summing
+	dupes := filepath.Join(td, "dupes.txt")
+	require.NoError(t, os.WriteFile(dupes, []byte(""+
+		"0000000000000000000000000000000000000001:5\n"+
+		"0000000000000000000000000000000000000001:7\n"+
+		"0000000000000000000000000000000000000001\n"+
+		"0000000000000000000000000000000000000006:6\n"), 0o644))
+	scanner, err = New(left, dupes)
+	require.NoError(t, err)
+	out := filepath.Join(td, "dupes.gz")
+	report, err = scanner.Merge(ctx, out, MergeSum)
+	require.NoError(t, err)
+	assert.Equal(t, ""+
+		"0000000000000000000000000000000000000001:16\n"+
+		"0000000000000000000000000000000000000002:2\n"+
+		"0000000000000000000000000000000000000003\n"+
+		"0000000000000000000000000000000000000004:4\n"+
+		"0000000000000000000000000000000000000006:6\n", testReadGZ(t, out))
+	assert.Equal(t, uint64(2), report.Duplicates)
+}
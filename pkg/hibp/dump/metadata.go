@@ -0,0 +1,144 @@
+package dump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// TotalPrefixes is the number of hash prefixes (5 hex digits) of the HIBP API.
+// A complete dump covers all of them.
+const TotalPrefixes = 1 << 20
+
+// Metadata describes the provenance of a dump. It is stored in a JSON sidecar
+// next to the dump.
+type Metadata struct {
+	// Source is the URL the dump was downloaded from or the operation that
+	// created it, e.g. "merge".
+	Source string `json:"source"`
+	// Inputs are the dumps this dump was created from, if any.
+	Inputs   []string  `json:"inputs,omitempty"`
+	HashMode string    `json:"hash_mode"`
+	Started  time.Time `json:"started"`
+	// Finished is when the data was complete. Dumps created from other dumps
+	// inherit it from the oldest input, they are no newer than that.
+	Finished time.Time `json:"finished"`
+	// Prefixes is the number of hash prefixes (5 hex digits) with at least
+	// one entry.
+	Prefixes uint64 `json:"prefixes"`
+	Entries  uint64 `json:"entries"`
+	// SHA256 is the checksum of the dump file as stored on disk.
+	SHA256 string `json:"sha256"`
+}
+
+// MetadataFilename returns the location of the metadata sidecar for the given
+// dump.
+func MetadataFilename(fn string) string {
+	return fn + ".meta.json"
+}
+
+// WriteMetadata writes the metadata sidecar for the given dump.
+func WriteMetadata(fn string, meta *Metadata) error {
+	buf, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(MetadataFilename(fn), append(buf, '\n'), 0o644)
+}
+
+// ReadMetadata reads the metadata sidecar of the given dump.
+func ReadMetadata(fn string) (*Metadata, error) {
+	buf, err := os.ReadFile(MetadataFilename(fn))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &Metadata{}
+	if err := json.Unmarshal(buf, meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata %s: %w", MetadataFilename(fn), err)
+	}
+
+	return meta, nil
+}
+
+// Created returns when the given dump was completed. This is the end of the
+// download (or the operation that created it) if the dump has metadata and
+// the modification time otherwise.
+func Created(fn string) (time.Time, error) {
+	if meta, err := ReadMetadata(fn); err == nil && !meta.Finished.IsZero() {
+		return meta.Finished, nil
+	}
+
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return fi.ModTime(), nil
+}
+
+// oldestCreated returns when the oldest of the given dumps was completed or
+// the zero time if none of them exists.
+func oldestCreated(fns []string) time.Time {
+	var oldest time.Time
+	for _, fn := range fns {
+		created, err := Created(fn)
+		if err != nil {
+			continue
+		}
+		if oldest.IsZero() || created.Before(oldest) {
+			oldest = created
+		}
+	}
+
+	return oldest
+}
+
+// fields returns the metadata as key value pairs for display.
+func (m *Metadata) fields() map[string]string {
+	out := map[string]string{
+		"source":    m.Source,
+		"hash mode": m.HashMode,
+		"entries":   strconv.FormatUint(m.Entries, 10),
+		"prefixes":  fmt.Sprintf("%d of %d", m.Prefixes, TotalPrefixes),
+		"sha256":    m.SHA256,
+	}
+	if !m.Started.IsZero() {
+		out["started"] = m.Started.Format(time.RFC3339)
+	}
+	if !m.Finished.IsZero() {
+		out["finished"] = m.Finished.Format(time.RFC3339)
+	}
+	for i, in := range m.Inputs {
+		out[fmt.Sprintf("input %d", i+1)] = in
+	}
+
+	return out
+}
+
+// fileChecksum returns the hex encoded SHA-256 checksum of the given file.
+func fileChecksum(fn string) (string, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close() //nolint:errcheck
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fh); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashPrefix returns the first 5 hex digits of the hash as an integer.
+func hashPrefix(sum *hashSum) uint32 {
+	return uint32(sum[0])<<12 | uint32(sum[1])<<4 | uint32(sum[2]>>4)
+}
@@ -0,0 +1,82 @@
+package dump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := ctxutil.WithHidden(t.Context(), true)
+
+	// no metadata, fall back to the modification time
+	fn := filepath.Join(td, "plain.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSampleSorted), 0o644))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(fn, mtime, mtime))
+
+	_, err := ReadMetadata(fn)
+	require.Error(t, err)
+	created, err := Created(fn)
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(created))
+
+	// writers record their provenance
+	scanner, err := New(fn)
+	require.NoError(t, err)
+	out := filepath.Join(td, "merged.gz")
+	_, err = scanner.Merge(ctx, out, MergeMax)
+	require.NoError(t, err)
+
+	meta, err := ReadMetadata(out)
+	require.NoError(t, err)
+	assert.Equal(t, "merge (max)", meta.Source)
+	assert.Equal(t, []string{fn}, meta.Inputs)
+	assert.Equal(t, uint64(10), meta.Entries)
+	// all sample hashes share the same prefix
+	assert.Equal(t, uint64(1), meta.Prefixes)
+	// the merged data is as old as its input, not the merge
+	assert.True(t, mtime.Equal(meta.Finished))
+	assert.True(t, meta.Started.After(mtime))
+
+	created, err = Created(out)
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(created))
+
+	// merging it again keeps the age of the oldest input
+	newer := filepath.Join(td, "newer.txt")
+	require.NoError(t, os.WriteFile(newer, []byte(testHibpSampleSorted), 0o644))
+	scanner, err = New(out, newer)
+	require.NoError(t, err)
+	again := filepath.Join(td, "again.gz")
+	_, err = scanner.Merge(ctx, again, MergeMax)
+	require.NoError(t, err)
+
+	created, err = Created(again)
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(created))
+
+	info, err := ReadInfo(ctx, out)
+	require.NoError(t, err)
+	assert.Equal(t, "merge (max)", info.Metadata["source"])
+
+	// the checksum is verified
+	r, err := Verify(ctx, out)
+	require.NoError(t, err)
+	assert.True(t, r.HasChecksum)
+	assert.Empty(t, r.Problems())
+
+	meta.SHA256 = "0000"
+	require.NoError(t, WriteMetadata(out, meta))
+	r, err = Verify(ctx, out)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"checksum does not match the metadata"}, r.Problems())
+}
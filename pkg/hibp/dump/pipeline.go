@@ -0,0 +1,139 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+)
+
+// chunkSize is the size of the buffers passed from the decompression to the
+// parsing stage.
+const chunkSize = 4 * 1024 * 1024
+
+// chunk is a batch of complete lines.
+type chunk struct {
+	buf []byte
+	// line is the number of lines preceding this chunk.
+	line uint64
+}
+
+// chunkReader is the decompression stage of the scan pipeline. It reads large
+// chunks of complete lines from a dump in a separate goroutine so decompression
+// and parsing can run in parallel. It uses a bounded pool of buffers that are
+// recycled, so a steady state scan does not allocate. Next may be called by
+// several parsing goroutines concurrently.
+type chunkReader struct {
+	chunks chan chunk
+	free   chan []byte
+	cancel context.CancelFunc
+	err    error
+}
+
+// newChunkReader starts reading from rdr using the given number of buffers.
+// The caller must call Close before closing rdr.
+func newChunkReader(ctx context.Context, rdr io.Reader, buffers int) *chunkReader {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &chunkReader{
+		chunks: make(chan chunk, buffers),
+		free:   make(chan []byte, buffers),
+		cancel: cancel,
+	}
+	for range buffers {
+		c.free <- make([]byte, chunkSize)
+	}
+
+	go c.run(ctx, rdr)
+
+	return c
+}
+
+func (c *chunkReader) run(ctx context.Context, rdr io.Reader) {
+	defer close(c.chunks)
+
+	// carry holds the incomplete last line of the previous chunk
+	var carry []byte
+	var line uint64
+	for {
+		var buf []byte
+		select {
+		case <-ctx.Done():
+			return
+		case buf = <-c.free:
+		}
+
+		n := copy(buf, carry)
+		m, err := io.ReadFull(rdr, buf[n:])
+		n += m
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				c.err = err
+
+				return
+			}
+			if n > 0 {
+				c.send(ctx, chunk{buf: buf[:n], line: line})
+			}
+
+			return
+		}
+
+		end := bytes.LastIndexByte(buf[:n], '\n') + 1
+		if end == 0 {
+			c.err = errors.New("line too long")
+
+			return
+		}
+		carry = append(carry[:0], buf[end:n]...)
+		if !c.send(ctx, chunk{buf: buf[:end], line: line}) {
+			return
+		}
+		line += uint64(bytes.Count(buf[:end], []byte{'\n'}))
+	}
+}
+
+func (c *chunkReader) send(ctx context.Context, ch chunk) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case c.chunks <- ch:
+		return true
+	}
+}
+
+// Next returns the next chunk of complete lines and recycles the previous one
+// of the calling goroutine.
+func (c *chunkReader) Next(prev chunk) (chunk, bool) {
+	if prev.buf != nil {
+		c.free <- prev.buf[:cap(prev.buf)]
+	}
+	ch, ok := <-c.chunks
+
+	return ch, ok
+}
+
+// Close stops the decompression stage and waits for it to finish. It may be
+// called more than once.
+func (c *chunkReader) Close() {
+	c.cancel()
+	for range c.chunks {
+		// drain until the decompression stage has stopped
+	}
+}
+
+// Err returns the first error encountered by the decompression stage. It must
+// only be called after Next returned false or after Close.
+func (c *chunkReader) Err() error {
+	return c.err
+}
+
+// nextLine returns the first line in buf (without the line break) and the
+// remainder of buf.
+func nextLine(buf []byte) ([]byte, []byte) {
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		return buf, nil
+	}
+
+	return buf[:i], buf[i+1:]
+}
@@ -0,0 +1,86 @@
+package dump
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// FilterDump copies all entries of infile seen at least minCount times into a
+// gzip compressed dump. Most of the volume of the HIBP dumps are hashes seen
+// only once or twice, so this creates much smaller dumps. Entries without a
+// count (v1 format) are always kept. The input must be ordered by hash.
+// Duplicate hashes are written once with their highest count. It returns the
+// number of hashes written.
+func FilterDump(ctx context.Context, infile, outfile string, minCount uint64) (uint64, error) {
+	if !isSorted(infile) {
+		return 0, fmt.Errorf("%s is not ordered by hash, use sort first", infile)
+	}
+	if !strings.HasSuffix(outfile, ".gz") {
+		outfile += ".gz"
+	}
+
+	fmt.Printf("Copying hashes seen at least %d times from %s into %s\n", minCount, infile, outfile)
+
+	w, err := newDumpWriter(outfile, gzip.DefaultCompression, &Metadata{
+		Source: fmt.Sprintf("filter (min count %d)", minCount),
+		Inputs: []string{infile},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer w.abort()
+
+	// repeated hashes are folded into one entry with the highest count, like
+	// diff and merge do
+	var total, duplicates uint64
+	var pending hashSum
+	var pendingCount uint64
+	flush := func() error {
+		if pendingCount > 0 && pendingCount < minCount {
+			return nil
+		}
+
+		return w.write(pending, pendingCount)
+	}
+	stats, err := eachDumpEntry(ctx, infile, func(sum hashSum, count uint64) error {
+		total++
+		if total > 1 && sum == pending {
+			pendingCount = max(pendingCount, count)
+			duplicates++
+
+			return nil
+		}
+		if total > 1 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		pending, pendingCount = sum, count
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if total > 0 {
+		if err := flush(); err != nil {
+			return 0, err
+		}
+	}
+	if stats.Malformed > 0 {
+		fmt.Printf("Warning: %s\n", stats)
+	}
+	if duplicates > 0 {
+		fmt.Printf("Warning: %s contains %d duplicate hashes, using the highest count\n", infile, duplicates)
+	}
+
+	if err := w.commit(); err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("Wrote %d of %d hashes to %s\n", w.entries, total, outfile)
+
+	return w.entries, nil
+}
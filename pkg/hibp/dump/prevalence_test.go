@@ -0,0 +1,93 @@
+package dump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterDump(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := t.Context()
+
+	in := filepath.Join(td, "dump.txt")
+	require.NoError(t, os.WriteFile(in, []byte(""+
+		"0000000000000000000000000000000000000001:1\n"+
+		"0000000000000000000000000000000000000002:2\n"+
+		"0000000000000000000000000000000000000003\n"+
+		"0000000000000000000000000000000000000004:40\n"), 0o644))
+
+	out := filepath.Join(td, "small")
+	n, err := FilterDump(ctx, in, out, 2)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), n)
+	assert.Equal(t, "0000000000000000000000000000000000000002:2\n"+
+		"0000000000000000000000000000000000000003\n"+
+		"0000000000000000000000000000000000000004:40\n", testReadGZ(t, out+".gz"))
+
+	// duplicates are folded before filtering
+	dupes := filepath.Join(td, "dupes.txt")
+	require.NoError(t, os.WriteFile(dupes, []byte(""+
+		"0000000000000000000000000000000000000001:1\n"+
+		"0000000000000000000000000000000000000001:3\n"+
+		"0000000000000000000000000000000000000002:2\n"+
+		"0000000000000000000000000000000000000002:1\n"+
+		"0000000000000000000000000000000000000004:1\n"+
+		"0000000000000000000000000000000000000004:1\n"), 0o644))
+	n, err = FilterDump(ctx, dupes, out, 2)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), n)
+	assert.Equal(t, "0000000000000000000000000000000000000001:3\n"+
+		"0000000000000000000000000000000000000002:2\n", testReadGZ(t, out+".gz"))
+
+	unsorted := filepath.Join(td, "unsorted.txt")
+	require.NoError(t, os.WriteFile(unsorted, []byte(testHibpSampleUnsorted), 0o644))
+	_, err = FilterDump(ctx, unsorted, out, 2)
+	require.Error(t, err)
+}
+
+func TestLookupCounts(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := t.Context()
+
+	in := []string{
+		"000000005ad76bd555c1d6d771de417a4b87e4b4",
+		"00000000DD7F2A1C68A35673713783CA390C9E93",
+		"0000000000000000000000000000000000000000",
+	}
+	want := map[string]uint64{
+		"000000005AD76BD555C1D6D771DE417A4B87E4B4": 0,
+		"00000000DD7F2A1C68A35673713783CA390C9E93": 42,
+	}
+
+	for name, content := range map[string]string{
+		"sorted.txt":   testHibpSampleSorted,
+		"unsorted.txt": testHibpSampleUnsorted,
+	} {
+		fn := filepath.Join(td, name)
+		require.NoError(t, os.WriteFile(fn, []byte(content), 0o644))
+
+		scanner, err := New(fn)
+		require.NoError(t, err)
+		assert.Equal(t, want, scanner.LookupCounts(ctx, append([]string{}, in...)), name)
+	}
+
+	// a v1 dump and a v2 dump with the same hash, the count is unknown
+	v1 := filepath.Join(td, "v1.txt")
+	require.NoError(t, os.WriteFile(v1, []byte("00000000DD7F2A1C68A35673713783CA390C9E93\n"), 0o644))
+	v2 := filepath.Join(td, "v2.txt")
+	require.NoError(t, os.WriteFile(v2, []byte("00000000DD7F2A1C68A35673713783CA390C9E93:2\n"), 0o644))
+	scanner, err := New(v1, v2)
+	require.NoError(t, err)
+	for range 10 {
+		assert.Equal(t, map[string]uint64{"00000000DD7F2A1C68A35673713783CA390C9E93": 0},
+			scanner.LookupCounts(ctx, []string{"00000000DD7F2A1C68A35673713783CA390C9E93"}))
+	}
+}
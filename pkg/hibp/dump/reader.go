@@ -0,0 +1,67 @@
+package dump
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kjk/lzmadec"
+)
+
+// dumpReader wraps the (possibly decompressing) reader of a dump and closes
+// all underlying readers in the right order.
+type dumpReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes all underlying readers, innermost first.
+func (d *dumpReader) Close() error {
+	var firstErr error
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		if err := d.closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// openDump opens a plain, gzip or 7z compressed dump for reading.
+func openDump(fn string) (io.ReadCloser, error) {
+	if strings.HasSuffix(fn, ".7z") {
+		arc, err := lzmadec.NewArchive(fn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the file with 7z %s: %w", fn, err)
+		}
+		if len(arc.Entries) < 1 {
+			return nil, fmt.Errorf("7z archive %s contains no entries", fn)
+		}
+		rzr, err := arc.GetFileReader(arc.Entries[0].Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed open %s in %s for reading: %w", arc.Entries[0].Path, fn, err)
+		}
+
+		return &dumpReader{Reader: rzr, closers: []io.Closer{rzr}}, nil
+	}
+
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", fn, err)
+	}
+
+	if !strings.HasSuffix(fn, ".gz") {
+		return fh, nil
+	}
+
+	gzr, err := gzip.NewReader(fh)
+	if err != nil {
+		_ = fh.Close()
+
+		return nil, fmt.Errorf("failed to open the file with gzip %s: %w", fn, err)
+	}
+
+	return &dumpReader{Reader: gzr, closers: []io.Closer{fh, gzr}}, nil
+}
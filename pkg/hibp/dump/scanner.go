@@ -1,7 +1,7 @@
 // Package dump implements an haveibeenpwned.com dump scanner. It is designed
 // to operate on HIBP SHA-1 dumps which are ordered by hash. It will work with
 // dumps ordered by prevalence, too. But processing those will take much, much
-// longer.
+// longer. Use Sort to convert them once.
 //
 // Unfortunately these dumps need to be unpacked before use, since there is no
 // 7z implementation for Go at the time of this writing.
@@ -9,23 +9,27 @@ package dump
 
 import (
 	"bufio"
-	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
-	"io"
-	"os"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/gopasspw/gopass/pkg/debug"
 	"github.com/gopasspw/gopass/pkg/fsutil"
-	"github.com/kjk/lzmadec"
 )
 
+// ErrNoDumps is returned if none of the given dumps can be used.
+var ErrNoDumps = errors.New("no valid dumps given")
+
 // Scanner is a HIBP dump scanner.
 type Scanner struct {
 	dumps []string
+
+	mu    sync.Mutex
+	stats map[string]FileStats
 }
 
 // New creates a new scanner. Provide a list of filenames to HIBP SHA-1 dumps.
@@ -39,7 +43,7 @@ func New(dumps ...string) (*Scanner, error) {
 		ok = append(ok, dump)
 	}
 	if len(ok) < 1 {
-		return nil, fmt.Errorf("no valid dumps given")
+		return nil, ErrNoDumps
 	}
 
 	return &Scanner{
@@ -50,17 +54,40 @@ func New(dumps ...string) (*Scanner, error) {
 // LookupBatch takes a slice SHA1 hashes and matches them against
 // the provided dumps.
 func (s *Scanner) LookupBatch(ctx context.Context, in []string) []string {
+	counts := s.LookupCounts(ctx, in)
+	if counts == nil {
+		return nil
+	}
+
+	out := make([]string, 0, len(counts))
+	for hash := range counts {
+		out = append(out, hash)
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+// LookupCounts works like LookupBatch but also returns the prevalence count of
+// every matched hash. If a hash is contained in more than one dump the highest
+// count wins. The count is zero, i.e. unknown, if any of those dumps has no
+// counts (v1 format, banned lists), so the match is never considered rare.
+func (s *Scanner) LookupCounts(ctx context.Context, in []string) map[string]uint64 {
 	if len(in) < 1 {
 		return nil
 	}
 
-	sort.Strings(in)
 	for i, hash := range in {
 		in[i] = strings.ToUpper(hash)
 	}
+	sort.Strings(in)
 
-	out := make([]string, 0, len(in))
-	results := make(chan string, len(in))
+	s.mu.Lock()
+	s.stats = make(map[string]FileStats, len(s.dumps))
+	s.mu.Unlock()
+
+	out := make(map[string]uint64, len(in))
+	results := make(chan match, len(in))
 	done := make(chan struct{}, len(s.dumps))
 
 	for _, fn := range s.dumps {
@@ -69,7 +96,15 @@ func (s *Scanner) LookupBatch(ctx context.Context, in []string) []string {
 
 	go func() {
 		for result := range results {
-			out = append(out, result)
+			count, found := out[result.hash]
+			switch {
+			case !found:
+				out[result.hash] = result.count
+			case count == 0 || result.count == 0:
+				out[result.hash] = 0
+			default:
+				out[result.hash] = max(count, result.count)
+			}
 		}
 		done <- struct{}{}
 	}()
@@ -83,283 +118,279 @@ func (s *Scanner) LookupBatch(ctx context.Context, in []string) []string {
 	return out
 }
 
-func (s *Scanner) scanFile(ctx context.Context, fn string, in []string, results chan string, done chan struct{}) {
+// match is a single input hash found in a dump.
+type match struct {
+	hash  string
+	count uint64
+}
+
+// Stats returns the line statistics of every dump processed by the last
+// call to LookupBatch.
+func (s *Scanner) Stats() []FileStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]FileStats, 0, len(s.stats))
+	for _, st := range s.stats {
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].File < out[j].File
+	})
+
+	return out
+}
+
+func (s *Scanner) scanFile(ctx context.Context, fn string, in []string, results chan match, done chan struct{}) {
+	stats := &FileStats{File: fn}
 	defer func() {
+		s.mu.Lock()
+		s.stats[fn] = *stats
+		s.mu.Unlock()
 		done <- struct{}{}
 	}()
 
+	if strings.HasSuffix(fn, ".gz") {
+		idx, err := loadIndex(fn)
+		switch {
+		case err != nil:
+			debug.Log("not using checkpoint index for %s: %s", fn, err)
+		case !idx.worthIt(in):
+			debug.Log("not using checkpoint index for %s: %d hashes visit too many checkpoints", fn, len(in))
+		default:
+			debug.Log("file %s has a checkpoint index", fn)
+			s.scanIndexedFile(ctx, fn, idx, in, results, stats)
+
+			return
+		}
+	}
+
 	if isSorted(fn) {
 		debug.Log("file %s appears to be sorted", fn)
-		s.scanSortedFile(ctx, fn, in, results)
+		s.scanSortedFile(ctx, fn, in, results, stats)
 
 		return
 	}
 	debug.Log("file %s is not sorted", fn)
-	s.scanUnsortedFile(ctx, fn, in, results)
+	s.scanUnsortedFile(ctx, fn, in, results, stats)
 }
 
 func isSorted(fn string) bool {
-	var rdr io.Reader
-	fh, err := os.Open(fn)
+	rdr, err := openDump(fn)
 	if err != nil {
 		return false
 	}
 	defer func() {
-		_ = fh.Close()
+		_ = rdr.Close()
 	}()
 
-	switch {
-	case strings.HasSuffix(fn, ".gz"):
-		gzr, err := gzip.NewReader(fh)
-		if err != nil {
-			return false
-		}
-		defer func() {
-			_ = gzr.Close()
-		}()
-		rdr = gzr
-	case strings.HasSuffix(fn, ".7z"):
-		arc, err := lzmadec.NewArchive(fn)
-		if err != nil {
-			return false
-		}
-		if len(arc.Entries) < 1 {
-			return false
-		}
-		rzr, err := arc.GetFileReader(arc.Entries[0].Path)
-		if err != nil {
-			return false
-		}
-		defer func() {
-			_ = rzr.Close()
-		}()
-		rdr = rzr
-	default:
-		rdr = fh
-	}
-
-	lineNo := 0
-	lastLine := ""
+	entries := 0
+	var sum, last hashSum
 	scanner := bufio.NewScanner(rdr)
 	for scanner.Scan() {
-		lineNo++
-		if lineNo > 100 {
-			return true
+		if _, kind := parseEntry(scanner.Bytes(), &sum); kind != lineEntry {
+			continue
 		}
 
-		line := scanner.Text()
-		if len(line) > 40 {
-			line = line[:40]
+		entries++
+		if entries > 100 {
+			return true
 		}
-		if line < lastLine {
+		if sum.compare(&last) < 0 {
 			return false
 		}
-		lastLine = line
+		last = sum
 	}
 
 	return true
 }
 
-func (s *Scanner) scanSortedFile(ctx context.Context, fn string, in []string, results chan string) {
-	var rdr io.Reader
-	fh, err := os.Open(fn)
+// scanSortedFile matches the sorted input hashes against a dump ordered by
+// hash in a single pass. Decompression runs in a separate stage and lines are
+// never converted to strings, so the scan is limited by the decompression
+// speed.
+func (s *Scanner) scanSortedFile(ctx context.Context, fn string, in []string, results chan match, stats *FileStats) {
+	rdr, err := openDump(fn)
 	if err != nil {
-		fmt.Printf("Failed to open file %s: %s", fn, err)
+		stats.Err = err
 
 		return
 	}
 	defer func() {
-		_ = fh.Close()
+		_ = rdr.Close()
 	}()
 
-	switch {
-	case strings.HasSuffix(fn, ".gz"):
-		gzr, err := gzip.NewReader(fh)
-		if err != nil {
-			fmt.Printf("Failed to open the file with gzip %s: %s", fn, err)
-
-			return
-		}
-		defer func() {
-			_ = gzr.Close()
-		}()
-		rdr = gzr
-	case strings.HasSuffix(fn, ".7z"):
-		arc, err := lzmadec.NewArchive(fn)
-		if err != nil {
-			fmt.Printf("Failed to open the file with 7z %s: %s", fn, err)
-
-			return
-		}
-		if len(arc.Entries) < 1 {
-			fmt.Printf("7z archive %s contains no entries", fn)
+	debug.Log("Checking file %s ...\n", fn)
 
-			return
-		}
-		rzr, err := arc.GetFileReader(arc.Entries[0].Path)
-		if err != nil {
-			fmt.Printf("Failed open %s in %s for reading: %s", arc.Entries[0].Path, fn, err)
+	// decode the input once, keep the original strings for reporting
+	sums := make([]hashSum, 0, len(in))
+	names := make([]string, 0, len(in))
+	for _, hash := range in {
+		var sum hashSum
+		if !decodeHash(&sum, []byte(hash)) {
+			debug.Log("skipping invalid input hash %q", hash)
 
-			return
+			continue
 		}
-		defer func() {
-			_ = rzr.Close()
-		}()
-		rdr = rzr
-	default:
-		rdr = fh
+		sums = append(sums, sum)
+		names = append(names, hash)
 	}
 
-	debug.Log("Checking file %s ...\n", fn)
+	cr := newChunkReader(ctx, rdr, 4)
+	defer cr.Close()
 
 	// index in input (sorted SHA sums)
 	i := 0
-	lineNo := 0
-	numMatches := 0
-	scanner := bufio.NewScanner(rdr)
+	var sum hashSum
+	var ch chunk
+	var ok bool
 SCAN:
-	for scanner.Scan() {
-		// check for context cancelation
-		select {
-		case <-ctx.Done():
-			break SCAN
-		default:
+	for i < len(sums) {
+		ch, ok = cr.Next(ch)
+		if !ok {
+			break
 		}
 
-		lineNo++
-		if in == nil {
-			results <- strings.TrimSpace(scanner.Text())
+		for rest := ch.buf; len(rest) > 0 && i < len(sums); {
+			var line []byte
+			line, rest = nextLine(rest)
+			stats.Lines++
+			count, kind := parseEntry(line, &sum)
+			switch kind {
+			case lineEntry:
+			case lineSkip:
+				continue
+			case lineMalformed:
+				if stats.malformed(ctx, stats.Lines, line) != nil {
+					break SCAN
+				}
+
+				continue
+			}
 
-			continue
+			// advance in sha sums from store until we've reached the position in
+			// the file
+			for i < len(sums) && sums[i].compare(&sum) < 0 {
+				i++
+			}
+			if i < len(sums) && sums[i] == sum {
+				results <- match{hash: names[i], count: count}
+				debug.Log("[%s] MATCH: %s", fn, names[i])
+				i++
+			}
 		}
 
-		if i >= len(in) {
-			break
+		select {
+		case <-ctx.Done():
+			break SCAN
+		default:
 		}
+	}
 
-		line := strings.TrimSpace(scanner.Text())
-		hash := line[:40]
-
-		if hash == in[i] {
-			results <- hash
-			debug.Log("[%s] MATCH at line %d: %s", fn, lineNo, hash)
-			numMatches++
-			// advance to next sha sum from store and next line in file
-			i++
-
-			continue
-		}
-		// advance in sha sums from store until we've reached the position in
-		// the file
-		for i < len(in) && line > in[i] {
-			i++
-		}
+	// stop the decompression stage before reading its error, the scan may
+	// have ended early
+	cr.Close()
+	if err := cr.Err(); err != nil && stats.Err == nil {
+		stats.Err = fmt.Errorf("failed to read %s: %w", fn, err)
 	}
 
 	debug.Log("Finished checking file %s", fn)
 }
 
-func (s *Scanner) scanUnsortedFile(ctx context.Context, fn string, in []string, results chan string) {
-	var rdr io.Reader
-	fh, err := os.Open(fn)
+// scanUnsortedFile matches the input hashes against a dump in any order. The
+// input is kept in a hash set, so the cost per line does not depend on the
+// number of input hashes. Parsing runs on all CPUs on batches of lines handed
+// out by the decompression stage.
+func (s *Scanner) scanUnsortedFile(ctx context.Context, fn string, in []string, results chan match, stats *FileStats) {
+	rdr, err := openDump(fn)
 	if err != nil {
-		fmt.Printf("Failed to open file %s: %s", fn, err)
+		stats.Err = err
 
 		return
 	}
 	defer func() {
-		_ = fh.Close()
+		_ = rdr.Close()
 	}()
 
-	switch {
-	case strings.HasSuffix(fn, ".gz"):
-		gzr, err := gzip.NewReader(fh)
-		if err != nil {
-			fmt.Printf("Failed to open the file with gzip %s: %s", fn, err)
-
-			return
-		}
-		defer func() {
-			_ = gzr.Close()
-		}()
-		rdr = gzr
-	case strings.HasSuffix(fn, ".7z"):
-		arc, err := lzmadec.NewArchive(fn)
-		if err != nil {
-			fmt.Printf("Failed to open the file with 7z %s: %s", fn, err)
-
-			return
-		}
-		if len(arc.Entries) < 1 {
-			fmt.Printf("7z archive %s contains no entries", fn)
-
-			return
-		}
-		rzr, err := arc.GetFileReader(arc.Entries[0].Path)
-		if err != nil {
-			fmt.Printf("Failed open %s in %s for reading: %s", arc.Entries[0].Path, fn, err)
+	set := make(map[hashSum]string, len(in))
+	for _, hash := range in {
+		var sum hashSum
+		if !decodeHash(&sum, []byte(hash)) {
+			debug.Log("skipping invalid input hash %q", hash)
 
-			return
+			continue
 		}
-		defer func() {
-			_ = rzr.Close()
-		}()
-		rdr = rzr
-	default:
-		rdr = fh
+		set[sum] = hash
 	}
 
-	lines := make(chan string, 1024)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	worker := runtime.NumCPU()
-	done := make(chan struct{}, worker)
-	for i := range worker {
-		debug.Log("[%d] Starting matcher ...", i)
-		go s.matcher(ctx, in, lines, results, done)
-	}
+	cr := newChunkReader(ctx, rdr, worker+1)
+	defer cr.Close()
 
-	debug.Log("Checking file %s ...\n", fn)
-	scanner := bufio.NewScanner(rdr)
-SCAN:
-	for scanner.Scan() {
-		// check for context cancelation
-		select {
-		case <-ctx.Done():
-			break SCAN
-		default:
-		}
+	debug.Log("Checking file %s with %d matchers ...\n", fn, worker)
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for range worker {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			local := s.matcher(ctx, cr, set, results, FileStats{File: fn})
+			if local.Err != nil {
+				cancel()
+			}
 
-		lines <- scanner.Text()
+			mu.Lock()
+			stats.add(local)
+			mu.Unlock()
+		}()
 	}
-	close(lines)
+	wg.Wait()
 
-	for range worker {
-		<-done
+	// stop the decompression stage before reading its error, the scan may
+	// have ended early
+	cr.Close()
+	if err := cr.Err(); err != nil && stats.Err == nil {
+		stats.Err = fmt.Errorf("failed to read %s: %w", fn, err)
 	}
 
 	debug.Log("Finished checking file %s", fn)
 }
 
-func (s *Scanner) matcher(ctx context.Context, in []string, lines chan string, results chan string, done chan struct{}) {
-	defer func() {
-		done <- struct{}{}
-	}()
-
-LINE:
-	for line := range lines {
-		// check for context cancelation
-		select {
-		case <-ctx.Done():
-			break LINE
-		default:
+func (s *Scanner) matcher(ctx context.Context, cr *chunkReader, set map[hashSum]string, results chan match, stats FileStats) FileStats {
+	var sum hashSum
+	var ch chunk
+	var ok bool
+	for {
+		ch, ok = cr.Next(ch)
+		if !ok {
+			return stats
 		}
 
-		line := strings.ToUpper(strings.TrimSpace(line))
-		hash := line[:40]
-		for _, candidate := range in {
-			if candidate == hash {
-				results <- hash
+		lineNo := ch.line
+		for rest := ch.buf; len(rest) > 0; {
+			var line []byte
+			line, rest = nextLine(rest)
+			lineNo++
+			stats.Lines++
+			count, kind := parseEntry(line, &sum)
+			switch kind {
+			case lineEntry:
+			case lineSkip:
+				continue
+			case lineMalformed:
+				if stats.malformed(ctx, lineNo, line) != nil {
+					return stats
+				}
+
+				continue
+			}
 
-				continue LINE
+			if hash, found := set[sum]; found {
+				results <- match{hash: hash, count: count}
 			}
 		}
 	}
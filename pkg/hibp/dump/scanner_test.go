@@ -4,8 +4,10 @@ import (
 	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -58,7 +60,7 @@ func TestScanner(t *testing.T) {
 
 	// no hibp dump, no scanner
 	_, err := New()
-	require.Error(t, err)
+	require.ErrorIs(t, err, ErrNoDumps)
 
 	// setup file and env (sorted)
 	fn := filepath.Join(td, "dump.txt")
@@ -105,3 +107,118 @@ func testWriteGZ(fn string, buf []byte) error {
 
 	return err
 }
+
+func benchmarkDump(b *testing.B) (string, int64) {
+	b.Helper()
+
+	buf := testDump(testSortedHashes(200000))
+	fn := filepath.Join(b.TempDir(), "dump.txt.gz")
+	require.NoError(b, testWriteGZ(fn, buf))
+
+	return fn, int64(len(buf))
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	fn, size := benchmarkDump(b)
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for b.Loop() {
+		rdr, err := openDump(fn)
+		require.NoError(b, err)
+		_, err = io.Copy(io.Discard, rdr)
+		require.NoError(b, err)
+		_ = rdr.Close()
+	}
+}
+
+func BenchmarkScanSorted(b *testing.B) {
+	fn, size := benchmarkDump(b)
+	ctx := b.Context()
+	s := &Scanner{dumps: []string{fn}}
+	// the last hash forces a full scan
+	in := []string{"0000000000000000000000000000000000000000", "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"}
+	results := make(chan match, len(in))
+
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		s.scanSortedFile(ctx, fn, in, results, &FileStats{File: fn})
+	}
+}
+
+func TestScannerMalformed(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := t.Context()
+
+	dump := "# comment\r\n" +
+		"000000005ad76bd555c1d6d771de417a4b87e4b4\r\n" +
+		"\r\n" +
+		"00000000A8DAE4228F821FB418F59826079BF3\r\n" +
+		"00000000DD7F2A1C68A35673713783CA390C9E93:42\r\n"
+	in := []string{"000000005AD76BD555C1D6D771DE417A4B87E4B4", "00000000DD7F2A1C68A35673713783CA390C9E93"}
+
+	for _, name := range []string{"sorted.txt", "sorted.txt.gz"} {
+		fn := filepath.Join(td, name)
+		if filepath.Ext(name) == ".gz" {
+			require.NoError(t, testWriteGZ(fn, []byte(dump)))
+		} else {
+			require.NoError(t, os.WriteFile(fn, []byte(dump), 0o644))
+		}
+
+		scanner, err := New(fn)
+		require.NoError(t, err)
+		assert.Equal(t, in, scanner.LookupBatch(ctx, append([]string{}, in...)))
+
+		stats := scanner.Stats()
+		require.Len(t, stats, 1)
+		require.NoError(t, stats[0].Err)
+		assert.Equal(t, uint64(1), stats[0].Malformed)
+		assert.Equal(t, uint64(4), stats[0].FirstMalformed)
+
+		// strict mode stops at the malformed line
+		assert.Equal(t, in[:1], scanner.LookupBatch(WithStrict(ctx, true), append([]string{}, in...)))
+		stats = scanner.Stats()
+		require.Len(t, stats, 1)
+		require.Error(t, stats[0].Err)
+	}
+
+	// unsorted
+	fn := filepath.Join(td, "unsorted.txt")
+	require.NoError(t, os.WriteFile(fn, []byte("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1\n"+dump), 0o644))
+
+	scanner, err := New(fn)
+	require.NoError(t, err)
+	got := scanner.LookupBatch(ctx, append([]string{}, in...))
+	sort.Strings(got)
+	assert.Equal(t, in, got)
+	assert.Equal(t, uint64(1), scanner.Stats()[0].Malformed)
+
+	scanner.LookupBatch(WithStrict(ctx, true), append([]string{}, in...))
+	require.Error(t, scanner.Stats()[0].Err)
+}
+
+func BenchmarkScanUnsorted(b *testing.B) {
+	fn, size := benchmarkDump(b)
+	ctx := b.Context()
+	s := &Scanner{dumps: []string{fn}}
+	// a store with a few thousand secrets
+	in := testSortedHashes(5000)
+	for i := range in {
+		in[i] = "F" + in[i][1:]
+	}
+	results := make(chan match, len(in))
+
+	b.SetBytes(size)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		s.scanUnsortedFile(ctx, fn, in, results, &FileStats{File: fn})
+		for len(results) > 0 {
+			<-results
+		}
+	}
+}
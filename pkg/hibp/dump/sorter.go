@@ -0,0 +1,258 @@
+package dump
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// DefaultSortMemory is the default memory budget of Sort in bytes.
+const DefaultSortMemory = 1024 * 1024 * 1024
+
+// sortEntry is a single entry held in memory during Sort.
+type sortEntry struct {
+	sum   hashSum
+	count uint64
+}
+
+// Sort converts all dumps of this scanner, in any order, into a single gzip
+// compressed dump ordered by hash without duplicates (keeping the highest
+// count). It is a disk-backed external merge sort: entries are sorted in
+// batches of at most memory bytes, written to temporary files in tmpdir and
+// merged afterwards. If the buffers of all runs don't fit into memory they
+// are merged in several passes.
+func (s *Scanner) Sort(ctx context.Context, outfile string, memory int64, tmpdir string) error {
+	if !strings.HasSuffix(outfile, ".gz") {
+		outfile += ".gz"
+	}
+	if memory < 1 {
+		memory = DefaultSortMemory
+	}
+
+	limit := max(int(memory/int64(unsafe.Sizeof(sortEntry{}))), 1024)
+
+	dir, err := os.MkdirTemp(tmpdir, "gopass-hibp-sort-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	fmt.Printf("Sorting %+v into %s using up to %d MB of memory ...\n", s.dumps, outfile, memory/1024/1024)
+
+	meta := &Metadata{
+		Source:  "sort",
+		Inputs:  s.dumps,
+		Started: time.Now(),
+	}
+
+	var runs []string
+	// allocated once, so the buffer never grows beyond the budget
+	entries := make([]sortEntry, 0, limit)
+	flush := func() error {
+		run := filepath.Join(dir, fmt.Sprintf("run-%06d.txt.gz", len(runs)))
+		// the runs are only read once, prefer speed over size
+		n, err := writeSorted(run, entries, gzip.BestSpeed, nil)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote sorted run #%d with %d hashes\n", len(runs), n)
+		runs = append(runs, run)
+		entries = entries[:0]
+
+		return nil
+	}
+
+	for _, fn := range s.dumps {
+		stats, err := eachDumpEntry(ctx, fn, func(sum hashSum, count uint64) error {
+			entries = append(entries, sortEntry{sum: sum, count: count})
+			if len(entries) < limit {
+				return nil
+			}
+
+			return flush()
+		})
+		if err != nil {
+			return err
+		}
+		if stats.Malformed > 0 {
+			fmt.Printf("Warning: %s\n", stats)
+		}
+	}
+
+	// everything fit into memory, no need for a merge
+	if len(runs) == 0 {
+		n, err := writeSorted(outfile, entries, gzip.DefaultCompression, meta)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d hashes to %s\n", n, outfile)
+
+		return nil
+	}
+
+	if len(entries) > 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	// the merge has its own budget
+	entries = nil
+
+	runs, err = mergeRuns(ctx, dir, runs, max(int(memory/mergeSourceMemory), 2))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Merging %d sorted runs ...\n", len(runs))
+	report, err := mergeFiles(ctx, runs, outfile, MergeMax, gzip.DefaultCompression, meta)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d hashes to %s\n", report.Entries, outfile)
+
+	return nil
+}
+
+// mergeRuns merges groups of at most fanIn runs into new runs in dir until
+// there are no more than fanIn left. Merged runs are removed.
+func mergeRuns(ctx context.Context, dir string, runs []string, fanIn int) ([]string, error) {
+	for pass := 0; len(runs) > fanIn; pass++ {
+		fmt.Printf("Merging %d sorted runs in groups of %d ...\n", len(runs), fanIn)
+
+		merged := make([]string, 0, len(runs)/fanIn+1)
+		for i := 0; i < len(runs); i += fanIn {
+			group := runs[i:min(i+fanIn, len(runs))]
+			if len(group) == 1 {
+				merged = append(merged, group[0])
+
+				continue
+			}
+
+			run := filepath.Join(dir, fmt.Sprintf("merge-%02d-%06d.txt.gz", pass, len(merged)))
+			if _, err := mergeFiles(ctx, group, run, MergeMax, gzip.BestSpeed, nil); err != nil {
+				return nil, err
+			}
+			for _, fn := range group {
+				_ = os.Remove(fn)
+			}
+			merged = append(merged, run)
+		}
+		runs = merged
+	}
+
+	return runs, nil
+}
+
+// writeSorted sorts the entries in place and writes them to fn, merging
+// duplicates. It returns the number of hashes written.
+func writeSorted(fn string, entries []sortEntry, level int, meta *Metadata) (uint64, error) {
+	slices.SortFunc(entries, func(a, b sortEntry) int {
+		return a.sum.compare(&b.sum)
+	})
+
+	w, err := newDumpWriter(fn, level, meta)
+	if err != nil {
+		return 0, err
+	}
+	defer w.abort()
+
+	for i := 0; i < len(entries); {
+		e := entries[i]
+		for i++; i < len(entries) && entries[i].sum == e.sum; i++ {
+			e.count = max(e.count, entries[i].count)
+		}
+		if err := w.write(e.sum, e.count); err != nil {
+			return 0, err
+		}
+	}
+
+	return w.entries, w.commit()
+}
+
+// WriteDump writes the given SHA-1 hashes into a gzip compressed dump ordered by
+// hash without duplicates. The dump has no counts (v1 format). source is
+// recorded in its metadata. It returns the number of hashes written.
+func WriteDump(outfile string, hashes []string, source string) (uint64, error) {
+	if !strings.HasSuffix(outfile, ".gz") {
+		outfile += ".gz"
+	}
+
+	entries := make([]sortEntry, len(hashes))
+	for i, hash := range hashes {
+		if !decodeHash(&entries[i].sum, []byte(hash)) {
+			return 0, fmt.Errorf("invalid SHA-1 hash %q", hash)
+		}
+	}
+
+	return writeSorted(outfile, entries, gzip.DefaultCompression, &Metadata{Source: source})
+}
+
+// eachDumpEntry calls cb for every entry of the given dump in file order.
+func eachDumpEntry(ctx context.Context, fn string, cb func(hashSum, uint64) error) (*FileStats, error) {
+	stats := &FileStats{File: fn}
+
+	rdr, err := openDump(fn)
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		_ = rdr.Close()
+	}()
+
+	cr := newChunkReader(ctx, rdr, 2)
+	defer cr.Close()
+
+	var sum hashSum
+	var ch chunk
+	var ok bool
+	for {
+		ch, ok = cr.Next(ch)
+		if !ok {
+			break
+		}
+
+		for rest := ch.buf; len(rest) > 0; {
+			var line []byte
+			line, rest = nextLine(rest)
+			stats.Lines++
+
+			count, kind := parseEntry(line, &sum)
+			switch kind {
+			case lineEntry:
+			case lineSkip:
+				continue
+			case lineMalformed:
+				if err := stats.malformed(ctx, stats.Lines, line); err != nil {
+					return stats, err
+				}
+
+				continue
+			}
+
+			if err := cb(sum, count); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return stats, fmt.Errorf("user aborted")
+	default:
+	}
+
+	if err := cr.Err(); err != nil {
+		return stats, fmt.Errorf("failed to read %s: %w", fn, err)
+	}
+
+	return stats, nil
+}
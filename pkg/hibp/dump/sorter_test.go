@@ -0,0 +1,129 @@
+package dump
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSort(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := ctxutil.WithHidden(t.Context(), true)
+
+	hashes := testSortedHashes(5000)
+	want := testDump(hashes)
+
+	// shuffle and add some duplicates with lower counts
+	shuffled := append([]string{}, hashes...)
+	rand.New(rand.NewSource(42)).Shuffle(len(shuffled), func(i, j int) { //nolint:gosec
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	counts := make(map[string]int, len(hashes))
+	for i, h := range hashes {
+		counts[h] = i%100 + 1
+	}
+	buf := []byte("# prevalence ordered\n")
+	for i, h := range shuffled {
+		buf = append(buf, []byte(h+":"+strconv.Itoa(counts[h])+"\n")...)
+		if i%10 == 0 {
+			buf = append(buf, []byte(h+":1\n")...)
+		}
+	}
+
+	fn := filepath.Join(td, "unsorted.txt")
+	require.NoError(t, os.WriteFile(fn, buf, 0o644))
+	scanner, err := New(fn)
+	require.NoError(t, err)
+
+	// everything in memory
+	out := filepath.Join(td, "memory.gz")
+	require.NoError(t, scanner.Sort(ctx, out, 0, td))
+	assert.Equal(t, string(want), testReadGZ(t, out))
+
+	// several runs
+	out = filepath.Join(td, "runs.gz")
+	require.NoError(t, scanner.Sort(ctx, out, 1, td))
+	assert.Equal(t, string(want), testReadGZ(t, out))
+
+	// the temporary runs are removed, only the outputs and their metadata are left
+	entries, err := os.ReadDir(td)
+	require.NoError(t, err)
+	assert.Len(t, entries, 5)
+
+	meta, err := ReadMetadata(out)
+	require.NoError(t, err)
+	assert.Equal(t, "sort", meta.Source)
+	assert.Equal(t, HashModeSHA1, meta.HashMode)
+	assert.Equal(t, uint64(strings.Count(string(want), "\n")), meta.Entries)
+	sum, err := fileChecksum(out)
+	require.NoError(t, err)
+	assert.Equal(t, sum, meta.SHA256)
+
+	// the result can be scanned with the sorted fast path
+	assert.True(t, isSorted(out))
+}
+
+func TestMergeRuns(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := ctxutil.WithHidden(t.Context(), true)
+
+	hashes := testSortedHashes(500)
+	runs := make([]string, 0, 5)
+	for i := range 5 {
+		run := filepath.Join(td, "run-"+strconv.Itoa(i)+".txt.gz")
+		var part []string
+		for j := i; j < len(hashes); j += 5 {
+			part = append(part, hashes[j])
+		}
+		_, err := WriteDump(run, part, "test")
+		require.NoError(t, err)
+		runs = append(runs, run)
+	}
+
+	merged, err := mergeRuns(ctx, td, runs, 2)
+	require.NoError(t, err)
+	assert.Len(t, merged, 2)
+
+	out := filepath.Join(td, "out.gz")
+	_, err = mergeFiles(ctx, merged, out, MergeMax, 6, nil)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join(hashes, "\n")+"\n", testReadGZ(t, out))
+
+	// nothing to do
+	merged, err = mergeRuns(ctx, td, merged, 2)
+	require.NoError(t, err)
+	assert.Len(t, merged, 2)
+}
+
+func TestWriteDump(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+
+	out := filepath.Join(td, "banned")
+	n, err := WriteDump(out, []string{
+		"0000000000000000000000000000000000000002",
+		"0000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000002",
+		"000000000000000000000000000000000000000a",
+	}, "test")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), n)
+	assert.Equal(t, "0000000000000000000000000000000000000001\n"+
+		"0000000000000000000000000000000000000002\n"+
+		"000000000000000000000000000000000000000A\n", testReadGZ(t, out+".gz"))
+
+	_, err = WriteDump(out, []string{"foo"}, "test")
+	require.Error(t, err)
+}
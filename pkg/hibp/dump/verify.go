@@ -0,0 +1,260 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Hash modes of HIBP dumps.
+const (
+	HashModeSHA1  = "SHA-1"
+	HashModeNTLM  = "NTLM"
+	HashModeMixed = "mixed"
+)
+
+// Formats of HIBP dumps.
+const (
+	FormatV1    = "v1 (hash only)"
+	FormatV2    = "v2 (hash:count)"
+	FormatMixed = "mixed"
+)
+
+// VerifyReport describes the integrity of a single dump.
+type VerifyReport struct {
+	File     string
+	Format   string
+	HashMode string
+	// Lines is the number of lines read.
+	Lines uint64
+	// Entries is the number of valid hashes.
+	Entries uint64
+	// WithCount is the number of entries with a prevalence count.
+	WithCount uint64
+	// Malformed is the number of lines that could not be parsed.
+	Malformed      uint64
+	FirstMalformed uint64
+	// Unordered is the number of entries smaller than their predecessor.
+	Unordered      uint64
+	FirstUnordered uint64
+	// Duplicates is the number of entries equal to their predecessor.
+	Duplicates     uint64
+	FirstDuplicate uint64
+	// HasChecksum is true if the dump has a metadata sidecar with a checksum.
+	HasChecksum bool
+	ChecksumOK  bool
+
+	sha1 uint64
+	ntlm uint64
+}
+
+// Sorted returns true if the dump is strictly ordered by hash.
+func (r *VerifyReport) Sorted() bool {
+	return r.Unordered == 0 && r.Duplicates == 0
+}
+
+// Problems returns a list of all problems found. An empty list means the dump
+// is fine to use with all fast paths.
+func (r *VerifyReport) Problems() []string {
+	var problems []string
+	if r.Entries == 0 {
+		problems = append(problems, "no entries")
+	}
+	if r.Malformed > 0 {
+		problems = append(problems, fmt.Sprintf("%d malformed lines (first at line %d)", r.Malformed, r.FirstMalformed))
+	}
+	if r.Unordered > 0 {
+		problems = append(problems, fmt.Sprintf("%d entries out of order (first at line %d)", r.Unordered, r.FirstUnordered))
+	}
+	if r.Duplicates > 0 {
+		problems = append(problems, fmt.Sprintf("%d duplicate entries (first at line %d)", r.Duplicates, r.FirstDuplicate))
+	}
+	if r.HashMode == HashModeMixed {
+		problems = append(problems, fmt.Sprintf("mixed hash modes (%d SHA-1, %d NTLM)", r.sha1, r.ntlm))
+	}
+	if r.HasChecksum && !r.ChecksumOK {
+		problems = append(problems, "checksum does not match the metadata")
+	}
+
+	return problems
+}
+
+// String returns a human readable, multi-line report.
+func (r *VerifyReport) String() string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "File:       %s\n", r.File)
+	fmt.Fprintf(sb, "Format:     %s\n", r.Format)
+	fmt.Fprintf(sb, "Hash mode:  %s\n", r.HashMode)
+	fmt.Fprintf(sb, "Lines:      %d\n", r.Lines)
+	fmt.Fprintf(sb, "Entries:    %d\n", r.Entries)
+	if r.Unordered > 0 {
+		fmt.Fprintf(sb, "Sorted:     no (%d entries out of order, first at line %d)\n", r.Unordered, r.FirstUnordered)
+	} else {
+		fmt.Fprintf(sb, "Sorted:     yes\n")
+	}
+	fmt.Fprintf(sb, "Duplicates: %d\n", r.Duplicates)
+	fmt.Fprintf(sb, "Malformed:  %d\n", r.Malformed)
+	switch {
+	case !r.HasChecksum:
+		fmt.Fprintf(sb, "Checksum:   unknown\n")
+	case r.ChecksumOK:
+		fmt.Fprintf(sb, "Checksum:   ok\n")
+	default:
+		fmt.Fprintf(sb, "Checksum:   mismatch\n")
+	}
+
+	return sb.String()
+}
+
+// Verify reads the whole dump and checks its format, order and integrity.
+// Unlike the scanner it doesn't stop at the first unordered line. If the dump
+// has metadata its checksum is verified as well. It only returns an error if
+// the dump could not be read completely.
+func Verify(ctx context.Context, fn string) (*VerifyReport, error) {
+	r := &VerifyReport{File: fn}
+
+	if meta, err := ReadMetadata(fn); err == nil && meta.SHA256 != "" {
+		sum, err := fileChecksum(fn)
+		if err != nil {
+			return r, err
+		}
+		r.HasChecksum = true
+		r.ChecksumOK = sum == meta.SHA256
+	}
+
+	rdr, err := openDump(fn)
+	if err != nil {
+		return r, err
+	}
+	defer func() {
+		_ = rdr.Close()
+	}()
+
+	cr := newChunkReader(ctx, rdr, 4)
+	defer cr.Close()
+
+	var cur, last [20]byte
+	var lastLen int
+	var ch chunk
+	var ok bool
+	for {
+		ch, ok = cr.Next(ch)
+		if !ok {
+			break
+		}
+
+		for rest := ch.buf; len(rest) > 0; {
+			var line []byte
+			line, rest = nextLine(rest)
+			r.Lines++
+
+			n, hasCount, kind := verifyLine(line, &cur)
+			switch kind {
+			case lineEntry:
+			case lineSkip:
+				continue
+			case lineMalformed:
+				r.Malformed++
+				if r.FirstMalformed == 0 {
+					r.FirstMalformed = r.Lines
+				}
+
+				continue
+			}
+
+			r.Entries++
+			if hasCount {
+				r.WithCount++
+			}
+			if n == 16 {
+				r.ntlm++
+			} else {
+				r.sha1++
+			}
+
+			if r.Entries > 1 {
+				switch c := bytes.Compare(cur[:n], last[:lastLen]); {
+				case c < 0:
+					r.Unordered++
+					if r.FirstUnordered == 0 {
+						r.FirstUnordered = r.Lines
+					}
+				case c == 0:
+					r.Duplicates++
+					if r.FirstDuplicate == 0 {
+						r.FirstDuplicate = r.Lines
+					}
+				}
+			}
+			last, lastLen = cur, n
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return r, fmt.Errorf("user aborted")
+	default:
+	}
+	if err := cr.Err(); err != nil {
+		return r, fmt.Errorf("failed to read %s: %w", fn, err)
+	}
+
+	r.finish()
+
+	return r, nil
+}
+
+func (r *VerifyReport) finish() {
+	switch {
+	case r.ntlm > 0 && r.sha1 > 0:
+		r.HashMode = HashModeMixed
+	case r.ntlm > 0:
+		r.HashMode = HashModeNTLM
+	default:
+		r.HashMode = HashModeSHA1
+	}
+
+	switch {
+	case r.WithCount == r.Entries:
+		r.Format = FormatV2
+	case r.WithCount == 0:
+		r.Format = FormatV1
+	default:
+		r.Format = FormatMixed
+	}
+}
+
+// verifyLine works like parseEntry but also accepts NTLM hashes (32 hex
+// digits). It returns the length of the binary hash and if a count was given.
+func verifyLine(line []byte, sum *[20]byte) (int, bool, lineKind) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] == '#' {
+		return 0, false, lineSkip
+	}
+
+	hash, count, hasCount := bytes.Cut(line, []byte{':'})
+	if len(hash) != 40 && len(hash) != 32 {
+		return 0, false, lineMalformed
+	}
+	for i := 0; i < len(hash); i += 2 {
+		hi, lo := unhex[hash[i]], unhex[hash[i+1]]
+		if hi > 0x0f || lo > 0x0f {
+			return 0, false, lineMalformed
+		}
+		sum[i/2] = hi<<4 | lo
+	}
+	if !hasCount {
+		return len(hash) / 2, false, lineEntry
+	}
+	if len(count) == 0 || len(count) > 19 {
+		return 0, false, lineMalformed
+	}
+	for _, c := range count {
+		if c < '0' || c > '9' {
+			return 0, false, lineMalformed
+		}
+	}
+
+	return len(hash) / 2, true, lineEntry
+}
@@ -0,0 +1,74 @@
+package dump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	td := t.TempDir()
+	ctx := t.Context()
+
+	// a valid dump
+	fn := filepath.Join(td, "sorted.txt.gz")
+	require.NoError(t, testWriteGZ(fn, testDump(testSortedHashes(500))))
+
+	r, err := Verify(ctx, fn)
+	require.NoError(t, err)
+	assert.Empty(t, r.Problems())
+	assert.True(t, r.Sorted())
+	assert.Equal(t, uint64(500), r.Entries)
+	assert.Equal(t, FormatV2, r.Format)
+	assert.Equal(t, HashModeSHA1, r.HashMode)
+	assert.Contains(t, r.String(), "Sorted:     yes")
+
+	// mostly sorted with a bad region after the first 100 lines
+	hashes := testSortedHashes(500)
+	hashes[300], hashes[301] = hashes[301], hashes[300]
+	hashes[400] = hashes[399]
+	buf := append(testDump(hashes), []byte("00000000A8DAE4228F821FB418F59826079BF3\nv1-line\n")...)
+	fn = filepath.Join(td, "broken.txt")
+	require.NoError(t, os.WriteFile(fn, buf, 0o644))
+
+	r, err = Verify(ctx, fn)
+	require.NoError(t, err)
+	assert.False(t, r.Sorted())
+	assert.Equal(t, uint64(1), r.Unordered)
+	assert.Equal(t, uint64(302), r.FirstUnordered)
+	assert.Equal(t, uint64(1), r.Duplicates)
+	assert.Equal(t, uint64(401), r.FirstDuplicate)
+	assert.Equal(t, uint64(2), r.Malformed)
+	assert.Len(t, r.Problems(), 3)
+
+	// NTLM, v1
+	fn = filepath.Join(td, "ntlm.txt")
+	require.NoError(t, os.WriteFile(fn, []byte("# NTLM\n"+
+		"00000000000000000000000000000001\r\n"+
+		"00000000000000000000000000000002\r\n"), 0o644))
+
+	r, err = Verify(ctx, fn)
+	require.NoError(t, err)
+	assert.Empty(t, r.Problems())
+	assert.Equal(t, FormatV1, r.Format)
+	assert.Equal(t, HashModeNTLM, r.HashMode)
+
+	// mixed
+	require.NoError(t, os.WriteFile(fn, []byte(""+
+		"00000000000000000000000000000001\n"+
+		"0000000000000000000000000000000000000002:2\n"), 0o644))
+
+	r, err = Verify(ctx, fn)
+	require.NoError(t, err)
+	assert.Equal(t, FormatMixed, r.Format)
+	assert.Equal(t, HashModeMixed, r.HashMode)
+	assert.Contains(t, r.Problems(), "mixed hash modes (1 SHA-1, 1 NTLM)")
+
+	_, err = Verify(ctx, filepath.Join(td, "missing.txt"))
+	require.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gopasspw/gopass/pkg/gopass"
+)
+
+const (
+	// policyKey set to "skip" opts a secret out of all checks.
+	policyKey = "hibp"
+	// maxCountKey tolerates leaks of a secret seen at most this many times.
+	maxCountKey = "hibp-max-count"
+)
+
+// policy is the check policy of a single secret, read from its keys.
+type policy struct {
+	skip bool
+	// maxCount is zero if the secret has no limit of its own.
+	maxCount uint64
+}
+
+// readPolicy returns the policy of a secret. Key names are case insensitive.
+func readPolicy(sec gopass.Secret) (policy, error) {
+	var p policy
+	for _, key := range sec.Keys() {
+		value, _ := sec.Get(key)
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(key) {
+		case policyKey:
+			if !strings.EqualFold(value, "skip") {
+				return p, fmt.Errorf("invalid value %q for %s, only 'skip' is supported", value, key)
+			}
+			p.skip = true
+		case maxCountKey:
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return p, fmt.Errorf("invalid value %q for %s: %w", value, key, err)
+			}
+			p.maxCount = n
+		}
+	}
+
+	return p, nil
+}
+
+// tolerated is a match allowed by the max count of a secret.
+type tolerated struct {
+	loc   location
+	sum   string
+	count uint64
+}
+
+// applyMaxCounts removes the locations of the given hashes from shaSums whose
+// secret tolerates the number of times the hash was seen. Unknown counts are
+// never tolerated. The input map is not modified.
+func applyMaxCounts(shaSums map[string][]location, hashes []string, counts map[string]uint64) (map[string][]location, []tolerated) {
+	var out map[string][]location
+	var allowed []tolerated
+	for _, sum := range hashes {
+		count := counts[sum]
+		if count < 1 {
+			continue
+		}
+
+		open := make([]location, 0, len(shaSums[sum]))
+		for _, loc := range shaSums[sum] {
+			if loc.MaxCount > 0 && count <= loc.MaxCount {
+				allowed = append(allowed, tolerated{loc: loc, sum: sum, count: count})
+
+				continue
+			}
+			open = append(open, loc)
+		}
+		if len(open) == len(shaSums[sum]) {
+			continue
+		}
+
+		if out == nil {
+			out = make(map[string][]location, len(shaSums))
+			for k, v := range shaSums {
+				out[k] = v
+			}
+		}
+		out[sum] = open
+	}
+
+	if out == nil {
+		return shaSums, nil
+	}
+
+	return out, allowed
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/gopass/apimock"
+	"github.com/gopasspw/gopass/pkg/gopass/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want policy
+		err  bool
+	}{
+		{in: "foobar\nuser: admin\n", want: policy{}},
+		{in: "foobar\nhibp: skip\n", want: policy{skip: true}},
+		{in: "foobar\nHIBP: Skip\n", want: policy{skip: true}},
+		{in: "foobar\nhibp-max-count: 10\n", want: policy{maxCount: 10}},
+		{in: "foobar\nhibp: never\n", err: true},
+		{in: "foobar\nhibp-max-count: ten\n", err: true},
+	} {
+		p, err := readPolicy(secrets.ParseAKV([]byte(tc.in)))
+		if tc.err {
+			require.Error(t, err, tc.in)
+
+			continue
+		}
+		require.NoError(t, err, tc.in)
+		assert.Equal(t, tc.want, p, tc.in)
+	}
+}
+
+func TestApplyMaxCounts(t *testing.T) {
+	foobar := sha1hex("foobar")
+	shaSums := map[string][]location{
+		foobar: {
+			{Secret: "web/a", Field: fieldPassword, MaxCount: 10},
+			{Secret: "web/b", Field: fieldPassword},
+		},
+	}
+
+	out, allowed := applyMaxCounts(shaSums, []string{foobar}, map[string]uint64{foobar: 5})
+	assert.Equal(t, []location{{Secret: "web/b", Field: fieldPassword}}, out[foobar])
+	require.Len(t, allowed, 1)
+	assert.Equal(t, uint64(5), allowed[0].count)
+	assert.Len(t, shaSums[foobar], 2)
+
+	// too often or unknown
+	for _, count := range []uint64{11, 0} {
+		out, allowed = applyMaxCounts(shaSums, []string{foobar}, map[string]uint64{foobar: count})
+		assert.Len(t, out[foobar], 2)
+		assert.Empty(t, allowed)
+	}
+}
+
+func TestHIBPDumpPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx := t.Context()
+	ctx = ctxutil.WithAlwaysYes(ctx, true)
+	ctx = ctxutil.WithHidden(ctx, true)
+
+	act := &hibp{
+		gp: apimock.New(),
+	}
+
+	fn := filepath.Join(dir, "leaked.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSample+"\n8843D7F92416211DE9EBB963FF4CE28125932878:5\n"), 0o644))
+
+	require.NoError(t, act.gp.Set(ctx, "demo", &apimock.Secret{Buf: []byte("foobar\nhibp-max-count: 4\n")}))
+	require.Error(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+
+	require.NoError(t, act.gp.Set(ctx, "demo", &apimock.Secret{Buf: []byte("foobar\nhibp-max-count: 5\n")}))
+	require.NoError(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+
+	require.NoError(t, act.gp.Set(ctx, "demo", &apimock.Secret{Buf: []byte("foobar\nhibp: skip\n")}))
+	require.NoError(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+	assert.Equal(t, []string{"demo"}, act.optedOut)
+
+	// an invalid policy is ignored
+	require.NoError(t, act.gp.Set(ctx, "demo", &apimock.Secret{Buf: []byte("foobar\nhibp: maybe\n")}))
+	require.Error(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+	assert.Empty(t, act.optedOut)
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// reportVersion is increased on incompatible changes of the report schema.
+const reportVersion = 1
+
+// Report formats.
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+	formatJUnit = "junit"
+)
+
+var formats = []string{formatText, formatJSON, formatCSV, formatJUnit}
+
+// Match states. Only leaked and probable matches fail the check.
+const (
+	statusLeaked       = "leaked"
+	statusProbable     = "probable"
+	statusRare         = "rare"
+	statusTolerated    = "tolerated"
+	statusAcknowledged = "acknowledged"
+)
+
+// report is the result of a check. It is written as JSON, so the field names
+// must not change.
+type report struct {
+	Version int `json:"version"`
+	// Backend is api, dump or filter.
+	Backend string `json:"backend"`
+	// Sources are the dumps or the filter used.
+	Sources  []string      `json:"sources"`
+	MinCount uint64        `json:"min_count"`
+	Finished time.Time     `json:"finished"`
+	Matches  []reportMatch `json:"matches"`
+	// OptedOut are the secrets skipped because of their policy.
+	OptedOut []string      `json:"opted_out"`
+	Errors   []reportError `json:"errors"`
+	Summary  summary       `json:"summary"`
+
+	// confirmed is set if probable matches were already looked up in the
+	// API, i.e. they are left from failed lookups.
+	confirmed bool
+}
+
+// summary counts the secrets of a check.
+type summary struct {
+	// Listed is the number of secrets in the store.
+	Listed int `json:"listed"`
+	// Decrypted is the number of secrets decrypted successfully.
+	Decrypted int `json:"decrypted"`
+	// Skipped is the number of secrets not selected or opted out.
+	Skipped int `json:"skipped"`
+	// Checked is the number of secrets looked up without errors.
+	Checked int `json:"checked"`
+	// Unchanged is the number of secrets not looked up again by an
+	// incremental check.
+	Unchanged int `json:"unchanged,omitempty"`
+	// Matched is the number of secrets with leaked or probable matches.
+	Matched int `json:"matched"`
+	// Failed is the number of secrets that could not be decrypted or looked
+	// up.
+	Failed int `json:"failed"`
+}
+
+// String returns the summary in a single line.
+func (s summary) String() string {
+	line := fmt.Sprintf("%d listed, %d decrypted, %d skipped, %d checked, %d matched, %d failed",
+		s.Listed, s.Decrypted, s.Skipped, s.Checked, s.Matched, s.Failed)
+	if s.Unchanged > 0 {
+		line += fmt.Sprintf(", %d unchanged", s.Unchanged)
+	}
+
+	return line
+}
+
+// reportMatch is a single value of a secret seen in a leak.
+type reportMatch struct {
+	Secret   string `json:"secret"`
+	Field    string `json:"field"`
+	Revision string `json:"revision,omitempty"`
+	// Hash is the first five characters of the SHA-1 hash, unless the full
+	// hash was requested.
+	Hash string `json:"hash"`
+	// Count is zero if it's unknown.
+	Count  uint64 `json:"count"`
+	Status string `json:"status"`
+	// Reason and Expires are set for acknowledged matches and for matches
+	// whose acknowledgement expired.
+	Reason  string `json:"reason,omitempty"`
+	Expires string `json:"expires,omitempty"`
+	// MaxCount is set for matches tolerated by the policy of the secret.
+	MaxCount uint64 `json:"max_count,omitempty"`
+
+	sum string
+	loc location
+}
+
+// reportError is a secret, hash or dump that could not be checked.
+type reportError struct {
+	Secret   string `json:"secret,omitempty"`
+	Field    string `json:"field,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	Error    string `json:"error"`
+}
+
+// failed returns true if there are leaked or probable matches.
+func (r *report) failed() bool {
+	return slices.ContainsFunc(r.Matches, func(m reportMatch) bool {
+		return m.Status == statusLeaked || m.Status == statusProbable
+	})
+}
+
+// groups returns the locations of all matches with the given state, keyed by
+// hash, and the hashes in order.
+func (r *report) groups(status string) (map[string][]location, []string) {
+	sums := make(map[string][]location)
+	hashes := make([]string, 0)
+	for _, m := range r.Matches {
+		if m.Status != status {
+			continue
+		}
+		if _, found := sums[m.sum]; !found {
+			hashes = append(hashes, m.sum)
+		}
+		sums[m.sum] = append(sums[m.sum], m.loc)
+	}
+
+	return sums, hashes
+}
+
+// hashText returns the hash as it appears in the report.
+func (s *hibp) hashText(sum string) string {
+	if s.fullHash || len(sum) < 5 {
+		return sum
+	}
+
+	return sum[:5]
+}
+
+// lookupFailed records a failed lookup for all secrets using the hash.
+func (s *hibp) lookupFailed(locs []location, sum string, err error) {
+	for _, loc := range locs {
+		s.errors = append(s.errors, reportError{
+			Secret:   loc.Secret,
+			Field:    loc.Field,
+			Revision: loc.Revision,
+			Hash:     s.hashText(sum),
+			Error:    err.Error(),
+		})
+	}
+}
+
+// newReport classifies the matches. Rare matches are seen less than minCount
+// times. Matches are tolerated by the policy of the secret or acknowledged by
+// the ignore list.
+func (s *hibp) newReport(backend string, sources []string, shaSums map[string][]location, counts map[string]uint64, matchList, probableList, rareList []string, minCount uint64) *report {
+	r := &report{
+		Version:  reportVersion,
+		Backend:  backend,
+		Sources:  append([]string{}, sources...),
+		MinCount: minCount,
+		Finished: time.Now(),
+		Matches:  []reportMatch{},
+		OptedOut: append([]string{}, s.optedOut...),
+		Errors:   append([]reportError{}, s.errors...),
+
+		confirmed: s.confirm,
+	}
+	add := func(sum string, loc location, status string) *reportMatch {
+		r.Matches = append(r.Matches, reportMatch{
+			Secret:   loc.Secret,
+			Field:    loc.Field,
+			Revision: loc.Revision,
+			Hash:     s.hashText(sum),
+			Count:    counts[sum],
+			Status:   status,
+			sum:      sum,
+			loc:      loc,
+		})
+
+		return &r.Matches[len(r.Matches)-1]
+	}
+
+	for _, sum := range rareList {
+		for _, loc := range shaSums[sum] {
+			add(sum, loc, statusRare)
+		}
+	}
+
+	shaSums, allowed := applyMaxCounts(shaSums, matchList, counts)
+	for _, a := range allowed {
+		add(a.sum, a.loc, statusTolerated).MaxCount = a.loc.MaxCount
+	}
+
+	shaSums, acked, expired := s.ignored.apply(shaSums, slices.Concat(matchList, probableList), time.Now())
+	for _, a := range acked {
+		m := add(a.sum, a.loc, statusAcknowledged)
+		m.Reason = a.entry.Reason
+		m.Expires = a.entry.Expires.Format(expiryLayout)
+	}
+	expiredEntries := make(map[location]*ignoreEntry, len(expired))
+	for _, a := range expired {
+		expiredEntries[a.loc] = a.entry
+	}
+
+	for _, list := range []struct {
+		status string
+		hashes []string
+	}{
+		{statusLeaked, matchList},
+		{statusProbable, probableList},
+	} {
+		for _, sum := range list.hashes {
+			for _, loc := range shaSums[sum] {
+				m := add(sum, loc, list.status)
+				if e, found := expiredEntries[loc]; found {
+					m.Reason = e.Reason
+					m.Expires = e.Expires.Format(expiryLayout)
+				}
+			}
+		}
+	}
+	r.Summary = s.summarize(r)
+
+	return r
+}
+
+func (s *hibp) summarize(r *report) summary {
+	failed := make(map[string]bool, len(r.Errors))
+	for _, e := range r.Errors {
+		if e.Secret != "" {
+			failed[e.Secret] = true
+		}
+	}
+	matched := make(map[string]bool, len(r.Matches))
+	// unchanged secrets sharing a hash with a secret that was looked up are
+	// checked as well
+	found := make(map[string]bool, len(r.Matches))
+	for _, m := range r.Matches {
+		found[m.Secret] = true
+		if m.Status == statusLeaked || m.Status == statusProbable {
+			matched[m.Secret] = true
+		}
+	}
+
+	sum := summary{
+		Listed:    s.listed,
+		Decrypted: len(s.checked) + len(s.optedOut),
+		Skipped:   s.listed - s.selected + len(s.optedOut),
+		Matched:   len(matched),
+		Failed:    len(failed),
+	}
+	for _, name := range s.checked {
+		switch {
+		case s.unchanged[name] && !found[name]:
+			sum.Unchanged++
+		case !failed[name]:
+			sum.Checked++
+		}
+	}
+
+	return sum
+}
+
+// writeReport writes the report in the configured format to the output.
+func (s *hibp) writeReport(r *report) error {
+	w := s.out
+	if w == nil {
+		w = os.Stdout
+	}
+
+	var err error
+	switch s.format {
+	case formatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	case formatCSV:
+		err = writeCSV(w, r)
+	case formatJUnit:
+		err = writeJUnit(w, r, s.checked)
+	default:
+		writeText(w, r)
+		writeSummary(w, r)
+
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the summary of machine readable reports goes to the log
+	writeSummary(s.logWriter(), r)
+
+	return nil
+}
+
+// writeSummary prints what could not be checked and the summary.
+func writeSummary(w io.Writer, r *report) {
+	if len(r.Errors) > 0 {
+		fmt.Fprintln(w, color.YellowString("Warning: The check is incomplete:"))
+		for _, e := range r.Errors {
+			if e.Secret == "" {
+				fmt.Fprintf(w, "\t- %s\n", e.Error)
+
+				continue
+			}
+			fmt.Fprintf(w, "\t- %s: %s\n", e.Secret, e.Error)
+		}
+	}
+	fmt.Fprintf(w, "Summary: %s\n", r.Summary)
+}
+
+// writeText prints all secrets using one of the matched hashes, grouped by
+// shared password.
+func writeText(w io.Writer, r *report) {
+	if len(r.OptedOut) > 0 {
+		fmt.Fprintf(w, "Skipped %d secrets opted out with '%s: skip':\n", len(r.OptedOut), policyKey)
+		for _, name := range r.OptedOut {
+			fmt.Fprintf(w, "\t- %s\n", name)
+		}
+	}
+
+	if sums, hashes := r.groups(statusRare); len(hashes) > 0 {
+		fmt.Fprintln(w, color.YellowString("Warning: Found some matches seen less than %d times:", r.MinCount))
+		printGroups(w, sums, hashes, "")
+	}
+
+	var passed bool
+	for _, status := range []string{statusTolerated, statusAcknowledged} {
+		var header bool
+		for _, m := range r.Matches {
+			if m.Status != status {
+				continue
+			}
+			passed = true
+			if status == statusTolerated {
+				if !header {
+					fmt.Fprintf(w, "Found some matches allowed by the %s of the secret:\n", maxCountKey)
+				}
+				fmt.Fprintf(w, "\t- %s (seen %d times, allowed up to %d)\n", m.loc, m.Count, m.MaxCount)
+			} else {
+				if !header {
+					fmt.Fprintln(w, "Found some acknowledged matches:")
+				}
+				fmt.Fprintf(w, "\t- %s (%s, until %s)\n", m.loc, m.Reason, m.Expires)
+			}
+			header = true
+		}
+	}
+	for _, m := range r.Matches {
+		if m.Expires != "" && m.Status != statusAcknowledged {
+			fmt.Fprintln(w, color.YellowString("Warning: The acknowledgement of %s expired on %s (%s)", m.loc, m.Expires, m.Reason))
+		}
+	}
+
+	if !r.failed() {
+		if passed {
+			fmt.Fprintln(w, "No other matches found")
+
+			return
+		}
+		fmt.Fprintln(w, "Good news - No matches found!")
+
+		return
+	}
+
+	var reused bool
+	if sums, hashes := r.groups(statusLeaked); len(hashes) > 0 {
+		fmt.Fprintln(w, "Oh no - Found some matches:")
+		reused = printGroups(w, sums, hashes, "")
+	}
+	if sums, hashes := r.groups(statusProbable); len(hashes) > 0 {
+		if r.confirmed {
+			fmt.Fprintln(w, "Found some probable matches:")
+		} else {
+			fmt.Fprintln(w, "Found some probable matches (use --confirm to verify them against the API):")
+		}
+		reused = printGroups(w, sums, hashes, " (probable)") || reused
+	}
+	fmt.Fprintln(w, "The passwords in the listed secrets were included in public leaks in the past. This means they are likely included in many word-list attacks and provide only very little security. Strongly consider changing those passwords!")
+	if reused {
+		fmt.Fprintln(w, color.RedString("Some of these passwords are used by more than one secret. Change those first!"))
+	}
+}
+
+// writeCSV writes one row per match and per error. Errors have the state
+// "error" and the message in the reason column.
+func writeCSV(w io.Writer, r *report) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"secret", "field", "revision", "hash", "count", "status", "reason", "expires", "max_count", "backend"})
+	for _, m := range r.Matches {
+		maxCount := ""
+		if m.MaxCount > 0 {
+			maxCount = strconv.FormatUint(m.MaxCount, 10)
+		}
+		_ = cw.Write([]string{m.Secret, m.Field, m.Revision, m.Hash, strconv.FormatUint(m.Count, 10), m.Status, m.Reason, m.Expires, maxCount, r.Backend})
+	}
+	for _, e := range r.Errors {
+		_ = cw.Write([]string{e.Secret, e.Field, e.Revision, e.Hash, "", "error", e.Error, "", "", r.Backend})
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"timestamp,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes one test case per checked, opted out or failed secret.
+// Secrets with leaked or probable matches fail, secrets that could not be
+// checked have an error.
+func writeJUnit(w io.Writer, r *report, checked []string) error {
+	cases := make(map[string]*junitCase, len(checked))
+	names := make([]string, 0, len(checked))
+	get := func(name string) *junitCase {
+		if c, found := cases[name]; found {
+			return c
+		}
+		c := &junitCase{Name: name, ClassName: "gopass-hibp." + r.Backend}
+		cases[name] = c
+		names = append(names, name)
+
+		return c
+	}
+	for _, name := range checked {
+		get(name)
+	}
+
+	suite := junitSuite{
+		Name: "gopass-hibp " + r.Backend,
+		Time: r.Finished.Format(time.RFC3339),
+	}
+	for _, m := range r.Matches {
+		c := get(m.Secret)
+		line := fmt.Sprintf("%s: %s seen %d times (%s)", m.loc, m.Hash, m.Count, m.Status)
+		if m.Status != statusLeaked && m.Status != statusProbable {
+			c.SystemOut = appendLine(c.SystemOut, line)
+
+			continue
+		}
+		if c.Failure == nil {
+			c.Failure = &junitMessage{Message: "password found in leaks", Type: m.Status}
+			suite.Failures++
+		}
+		c.Failure.Text = appendLine(c.Failure.Text, line)
+	}
+	for _, e := range r.Errors {
+		name := e.Secret
+		if name == "" {
+			// errors of a whole dump or filter
+			name = r.Backend
+		}
+		c := get(name)
+		if c.Error == nil {
+			c.Error = &junitMessage{Message: "secret could not be checked"}
+			suite.Errors++
+		}
+		c.Error.Text = appendLine(c.Error.Text, e.Error)
+	}
+	for _, name := range r.OptedOut {
+		get(name).Skipped = &junitMessage{Message: "opted out with '" + policyKey + ": skip'"}
+		suite.Skipped++
+	}
+
+	slices.Sort(names)
+	for _, name := range names {
+		suite.Cases = append(suite.Cases, *cases[name])
+	}
+	suite.Tests = len(suite.Cases)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
+
+func appendLine(text, line string) string {
+	if text == "" {
+		return line
+	}
+
+	return text + "\n" + line
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gopasspw/gopass/pkg/ctxutil"
+	"github.com/gopasspw/gopass/pkg/gopass"
+	"github.com/gopasspw/gopass/pkg/gopass/apimock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	foobar := sha1hex("foobar")
+	rare := sha1hex("rare")
+	shaSums := map[string][]location{
+		foobar: {
+			{Secret: "web/a", Field: fieldPassword},
+			{Secret: "web/b", Field: "pin", Revision: "1"},
+			{Secret: "web/c", Field: fieldPassword, MaxCount: 10},
+		},
+		rare: {{Secret: "web/d", Field: fieldPassword}},
+	}
+	counts := map[string]uint64{foobar: 5, rare: 1}
+
+	act := &hibp{
+		optedOut: []string{"web/e"},
+		checked:  []string{"web/a", "web/b", "web/c", "web/d"},
+		errors:   []reportError{{Secret: "web/f", Error: "decryption failed"}},
+	}
+	r := act.newReport("api", nil, shaSums, counts, []string{foobar}, nil, []string{rare}, 2)
+	assert.True(t, r.failed())
+
+	status := make(map[string]string, len(r.Matches))
+	for _, m := range r.Matches {
+		status[m.Secret] = m.Status
+		assert.Len(t, m.Hash, 5)
+	}
+	assert.Equal(t, map[string]string{
+		"web/a": statusLeaked,
+		"web/b": statusLeaked,
+		"web/c": statusTolerated,
+		"web/d": statusRare,
+	}, status)
+
+	// json
+	buf := &bytes.Buffer{}
+	act.out = buf
+	act.format = formatJSON
+	require.NoError(t, act.writeReport(r))
+	var out map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.InDelta(t, float64(reportVersion), out["version"], 0)
+	assert.Equal(t, "api", out["backend"])
+	assert.Equal(t, []any{"web/e"}, out["opted_out"])
+	assert.Len(t, out["matches"], 4)
+	assert.Len(t, out["errors"], 1)
+	assert.NotContains(t, buf.String(), foobar)
+
+	// full hashes only if asked for
+	act.fullHash = true
+	r = act.newReport("api", nil, shaSums, counts, []string{foobar}, nil, []string{rare}, 2)
+	buf.Reset()
+	require.NoError(t, act.writeReport(r))
+	assert.Contains(t, buf.String(), foobar)
+
+	// csv
+	buf.Reset()
+	act.format = formatCSV
+	require.NoError(t, act.writeReport(r))
+	rows, err := csv.NewReader(buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 6)
+	assert.Equal(t, "secret", rows[0][0])
+	assert.Equal(t, "error", rows[5][5])
+
+	// junit
+	buf.Reset()
+	act.format = formatJUnit
+	require.NoError(t, act.writeReport(r))
+	var suites junitSuites
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &suites))
+	require.Len(t, suites.Suites, 1)
+	suite := suites.Suites[0]
+	assert.Equal(t, 6, suite.Tests)
+	assert.Equal(t, 2, suite.Failures)
+	assert.Equal(t, 1, suite.Errors)
+	assert.Equal(t, 1, suite.Skipped)
+
+	// text
+	buf.Reset()
+	act.format = formatText
+	require.NoError(t, act.writeReport(r))
+	assert.Contains(t, buf.String(), "Oh no - Found some matches:")
+	assert.Contains(t, buf.String(), "web/b (field pin, revision 1)")
+
+	// the hint to confirm probable matches only without --confirm
+	r = act.newReport("filter", nil, shaSums, counts, nil, []string{foobar}, nil, 0)
+	buf.Reset()
+	require.NoError(t, act.writeReport(r))
+	assert.Contains(t, buf.String(), "use --confirm")
+	act.confirm = true
+	r = act.newReport("filter", nil, shaSums, counts, nil, []string{foobar}, nil, 0)
+	buf.Reset()
+	require.NoError(t, act.writeReport(r))
+	assert.Contains(t, buf.String(), "Found some probable matches:")
+	assert.NotContains(t, buf.String(), "use --confirm")
+}
+
+func TestHIBPDumpJSON(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx := t.Context()
+	ctx = ctxutil.WithAlwaysYes(ctx, true)
+	ctx = ctxutil.WithHidden(ctx, true)
+
+	buf := &bytes.Buffer{}
+	logs := &bytes.Buffer{}
+	act := &hibp{
+		gp:     apimock.New(),
+		format: formatJSON,
+		out:    buf,
+		log:    logs,
+	}
+	require.NoError(t, act.gp.Set(ctx, "baz", &apimock.Secret{Buf: []byte("foobar")}))
+
+	fn := filepath.Join(dir, "leaked.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSample+"\n8843D7F92416211DE9EBB963FF4CE28125932878:5\n"), 0o644))
+	require.Error(t, act.CheckDump(ctx, false, []string{fn}, 0, 0))
+
+	// progress and the summary go to the log, only the report to the output
+	assert.Contains(t, logs.String(), "Checking hashes against the provided dumps")
+	assert.Contains(t, logs.String(), "Summary: 1 listed")
+	assert.NotContains(t, buf.String(), "Summary:")
+
+	var r report
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &r))
+	assert.Equal(t, "dump", r.Backend)
+	assert.Equal(t, []string{fn}, r.Sources)
+	require.Len(t, r.Matches, 1)
+	assert.Equal(t, reportMatch{
+		Secret: "baz",
+		Field:  fieldPassword,
+		Hash:   "8843D",
+		Count:  5,
+		Status: statusLeaked,
+	}, r.Matches[0])
+}
+
+// brokenStore fails to decrypt the secrets in broken.
+type brokenStore struct {
+	*apimock.MockAPI
+
+	broken map[string]bool
+}
+
+func (b *brokenStore) Get(ctx context.Context, name, revision string) (gopass.Secret, error) {
+	if b.broken[name] {
+		return nil, fmt.Errorf("failed to decrypt %s", name)
+	}
+
+	return b.MockAPI.Get(ctx, name, revision)
+}
+
+func TestHIBPDumpIncomplete(t *testing.T) {
+	dir := t.TempDir()
+
+	ctx := t.Context()
+	ctx = ctxutil.WithAlwaysYes(ctx, true)
+	ctx = ctxutil.WithHidden(ctx, true)
+
+	gp := &brokenStore{MockAPI: apimock.New(), broken: map[string]bool{"web/broken": true}}
+	buf := &bytes.Buffer{}
+	act := &hibp{
+		gp:     gp,
+		format: formatJSON,
+		out:    buf,
+	}
+	for _, name := range []string{"web/ok", "web/broken", "web/skip"} {
+		require.NoError(t, gp.Set(ctx, name, &apimock.Secret{Buf: []byte("secret")}))
+	}
+	require.NoError(t, gp.Set(ctx, "web/optout", &apimock.Secret{Buf: []byte("secret\nhibp: skip\n")}))
+	act.selector = &selector{exclude: []string{"web/skip"}}
+
+	fn := filepath.Join(dir, "dump.txt")
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSample), 0o644))
+	err := act.CheckDump(ctx, false, []string{fn}, 0, 0)
+	require.ErrorIs(t, err, errIncomplete)
+	assert.Equal(t, exitIncomplete, exitCode(err))
+	assert.Contains(t, err.Error(), "1 secrets could not be checked")
+	assert.NotContains(t, err.Error(), "backend")
+
+	var r report
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &r))
+	assert.Equal(t, summary{
+		Listed:    4,
+		Decrypted: 2,
+		Skipped:   2,
+		Checked:   1,
+		Matched:   0,
+		Failed:    1,
+	}, r.Summary)
+	require.Len(t, r.Errors, 1)
+	assert.Equal(t, "web/broken", r.Errors[0].Secret)
+
+	// leaks take precedence
+	require.NoError(t, gp.Set(ctx, "web/ok", &apimock.Secret{Buf: []byte("foobar")}))
+	require.NoError(t, os.WriteFile(fn, []byte(testHibpSample+"\n8843D7F92416211DE9EBB963FF4CE28125932878:5\n"), 0o644))
+	err = act.CheckDump(ctx, false, []string{fn}, 0, 0)
+	require.ErrorIs(t, err, errLeaks)
+	assert.Equal(t, exitLeaks, exitCode(err))
+
+	assert.Equal(t, exitSetup, exitCode(act.CheckDump(ctx, false, nil, 0, 0)))
+
+	// a dump that can't be read is named as a failure of the backend
+	require.NoError(t, gp.Set(ctx, "web/ok", &apimock.Secret{Buf: []byte("secret")}))
+	gz := filepath.Join(dir, "broken.txt.gz")
+	require.NoError(t, testWriteGZ(gz, []byte(testHibpSample)))
+	content, err := os.ReadFile(gz)
+	require.NoError(t, err)
+	for i := 20; i < len(content)-8; i++ {
+		content[i] = 0xff
+	}
+	require.NoError(t, os.WriteFile(gz, content, 0o644))
+	err = act.CheckDump(ctx, false, []string{gz}, 0, 0)
+	require.ErrorIs(t, err, errIncomplete)
+	assert.Contains(t, err.Error(), "the dump backend failed")
+
+	// a failure after the check started is no setup error
+	act.out = failWriter{}
+	err = act.CheckDump(ctx, false, []string{fn}, 0, 0)
+	require.ErrorContains(t, err, "failed to write report")
+	assert.Equal(t, exitIncomplete, exitCode(err))
+}
+
+// failWriter fails every write.
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
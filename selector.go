@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// selector restricts the secrets to check. It is applied to the names returned
+// by the store before any secret is decrypted. A secret is selected if it is
+// below one of the prefixes (or there are none), matches one of the include
+// globs (or there are none) and does not match any exclude glob. Globs are
+// matched against the full name and each of its parent directories, so
+// "team/*" also matches "team/ops/db".
+type selector struct {
+	prefixes []string
+	include  []string
+	exclude  []string
+}
+
+func newSelector(prefixes, include, exclude []string) (*selector, error) {
+	sel := &selector{}
+	for _, p := range prefixes {
+		p = strings.Trim(p, "/")
+		if p == "" {
+			continue
+		}
+		sel.prefixes = append(sel.prefixes, p)
+	}
+
+	for _, globs := range []struct {
+		rules []string
+		out   *[]string
+	}{
+		{include, &sel.include},
+		{exclude, &sel.exclude},
+	} {
+		for _, glob := range globs.rules {
+			glob = strings.Trim(glob, "/")
+			if glob == "" {
+				continue
+			}
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("invalid secret pattern %q: %w", glob, err)
+			}
+			*globs.out = append(*globs.out, glob)
+		}
+	}
+
+	return sel, nil
+}
+
+// filter returns the selected names, keeping their order.
+func (sel *selector) filter(names []string) []string {
+	if sel == nil {
+		return names
+	}
+
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		if sel.selected(name) {
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
+
+func (sel *selector) selected(name string) bool {
+	// prefixes and globs are stored without leading or trailing slashes
+	name = strings.Trim(name, "/")
+	if len(sel.prefixes) > 0 && !sel.belowPrefix(name) {
+		return false
+	}
+	if len(sel.include) > 0 && !matchAny(sel.include, name) {
+		return false
+	}
+
+	return !matchAny(sel.exclude, name)
+}
+
+func (sel *selector) belowPrefix(name string) bool {
+	for _, p := range sel.prefixes {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchAny returns true if any glob matches the name or one of its parent
+// directories.
+func matchAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		// path.Dir ends with "/" for absolute names
+		for n := name; n != "." && n != "/"; n = path.Dir(n) {
+			if ok, _ := path.Match(glob, n); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelector(t *testing.T) {
+	names := []string{
+		"personal/bank",
+		"team/ops/db",
+		"team/ops/web",
+		"team/dev/db",
+		"teamwork/wiki",
+		"web",
+	}
+
+	// everything by default
+	var sel *selector
+	assert.Equal(t, names, sel.filter(names))
+
+	sel, err := newSelector(nil, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, names, sel.filter(names))
+
+	// prefixes only match whole path components
+	sel, err = newSelector([]string{"team/", "web"}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team/ops/db", "team/ops/web", "team/dev/db", "web"}, sel.filter(names))
+
+	// globs match parent directories as well
+	sel, err = newSelector(nil, []string{"team/*"}, []string{"*/dev"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team/ops/db", "team/ops/web"}, sel.filter(names))
+
+	sel, err = newSelector([]string{"team"}, nil, []string{"team/*/db"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team/ops/web"}, sel.filter(names))
+
+	// leading slashes are ignored and don't hang the glob match
+	assert.Equal(t, []string{"/team/ops/web"}, sel.filter([]string{"/team/ops/db", "/team/ops/web", "/web"}))
+	assert.False(t, matchAny([]string{"web"}, "/team/ops/db"))
+
+	_, err = newSelector(nil, []string{"team/["}, nil)
+	require.Error(t, err)
+}